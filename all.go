@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// loadAllConfigs parses all configurations from the configuration directory, skipping those
+// that cannot be parsed.
+func loadAllConfigs() map[string]*lib.Config {
+	paths, err := filepath.Glob(fmt.Sprintf("%s/*.yml", lib.GetConfigPath()))
+	if err != nil {
+		logrus.Fatalf("could not enumerate your configurations: %s", err.Error())
+	}
+
+	configs := make(map[string]*lib.Config)
+	for _, path := range paths {
+		config, err := lib.ParseConfig(path)
+		if err != nil {
+			logrus.Warnf("ignoring '%s': %s", path, err.Error())
+			continue
+		}
+
+		configs[lib.GetInstanceFromArg(path)] = config
+	}
+
+	return configs
+}
+
+func startAll(noRoutes bool) {
+	configs := loadAllConfigs()
+
+	instances := make([]string, 0)
+	for name, config := range configs {
+		if config.Autostart {
+			instances = append(instances, name)
+		}
+	}
+
+	waves, err := lib.StartOrder(configs, instances)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	failed := runWaves(waves, configs, func(instance string) error {
+		if tunnelState(instance) == tunnelUp {
+			Up("tunnel '%s' is already up", instance)
+			return nil
+		}
+
+		if _, err := startInstance(instance, noRoutes); err != nil {
+			return err
+		}
+
+		Up("tunnel '%s' has been brought up", instance)
+		return nil
+	})
+
+	if failed {
+		logrus.Fatal("some tunnels could not be brought up")
+	}
+}
+
+func stopAll() {
+	configs := loadAllConfigs()
+
+	instances := make([]string, 0)
+	for name := range configs {
+		if tunnelState(name) == tunnelUp {
+			instances = append(instances, name)
+		}
+	}
+
+	waves, err := lib.StopOrder(configs, instances)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	failed := runWaves(waves, configs, func(instance string) error {
+		if tunnelState(instance) != tunnelUp {
+			return nil
+		}
+
+		if _, err := stopInstance(instance); err != nil {
+			return err
+		}
+
+		Down("tunnel '%s' has been torn down", instance)
+		return nil
+	})
+
+	if failed {
+		logrus.Fatal("some tunnels could not be torn down")
+	}
+}
+
+// runWaves applies an action to all instances of a wave concurrently, one wave after the other.
+// When bringing tunnels up, instances depending on a failed instance are skipped.
+func runWaves(waves [][]string, configs map[string]*lib.Config, action func(string) error) bool {
+	failures := make(map[string]bool)
+
+	for _, wave := range waves {
+		var wg sync.WaitGroup
+
+		// Each goroutine only writes its own slot, failures are recorded once the wave is done
+		errs := make([]error, len(wave))
+
+		for idx, instance := range wave {
+			skip := false
+			for _, dep := range configs[instance].After {
+				if failures[dep] {
+					skip = true
+				}
+			}
+			if skip {
+				Down("tunnel '%s' was skipped because one of its dependencies failed", instance)
+				errs[idx] = fmt.Errorf("skipped")
+				continue
+			}
+
+			wg.Add(1)
+
+			go func(idx int, instance string) {
+				defer wg.Done()
+
+				if err := action(instance); err != nil {
+					logrus.Errorf("%s: %s", instance, err.Error())
+					errs[idx] = err
+				}
+			}(idx, instance)
+		}
+
+		wg.Wait()
+
+		for idx, err := range errs {
+			if err != nil {
+				failures[wave[idx]] = true
+			}
+		}
+	}
+
+	return len(failures) > 0
+}
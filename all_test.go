@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RunWaves(t *testing.T) {
+	configs := map[string]*lib.Config{
+		"uplink":    {},
+		"corporate": {After: []string{"uplink"}},
+		"backup":    {},
+		"lab":       {After: []string{"corporate"}},
+		"home":      {After: []string{"backup"}},
+	}
+	waves := [][]string{{"uplink", "backup"}, {"corporate", "home"}, {"lab"}}
+
+	var lock sync.Mutex
+	ran := make([]string, 0)
+
+	failed := runWaves(waves, configs, func(instance string) error {
+		lock.Lock()
+		ran = append(ran, instance)
+		lock.Unlock()
+
+		if instance == "uplink" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+
+	assert.True(t, failed)
+	assert.ElementsMatch(t, []string{"uplink", "backup", "home"}, ran)
+
+	ran = make([]string, 0)
+	failed = runWaves(waves, configs, func(instance string) error {
+		lock.Lock()
+		ran = append(ran, instance)
+		lock.Unlock()
+
+		return nil
+	})
+
+	assert.False(t, failed)
+	assert.Equal(t, 5, len(ran))
+	assert.Equal(t, "lab", ran[4])
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/apognu/wgctl/lib"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	"code.cloudfoundry.org/bytefmt"
@@ -55,9 +56,14 @@ func PrintSection(pad int, key string, value string, color *color.Color, args ..
 	fmt.Printf("%s\n", fmt.Sprintf(value, args...))
 }
 
-// FormatPSK formats a preshared key as a hex string
-func FormatPSK(psk wgtypes.Key) string {
-	return fmt.Sprintf("%x", psk[:])
+// FormatPSK formats a preshared key in the given encoding, or in the preferred one
+func FormatPSK(psk wgtypes.Key, encoding string) string {
+	if len(encoding) == 0 {
+		encoding = lib.PSKEncoding
+	}
+
+	k := lib.PresharedKey(psk[:])
+	return k.Format(encoding)
 }
 
 // FormatSubnet formats a net.IPNet as a string
@@ -88,6 +94,11 @@ func FormatTransfer(rx, tx int64) string {
 	return fmt.Sprintf("↓ %s ↑ %s", bytefmt.ByteSize(uint64(rx)), bytefmt.ByteSize(uint64(tx)))
 }
 
+// FormatRate formats a throughput expressed in bytes per second.
+func FormatRate(rate float64) string {
+	return fmt.Sprintf("%s/s", bytefmt.ByteSize(uint64(rate)))
+}
+
 // PrintStatus prints a status message ot be used for action return messages (OK or KO)
 func PrintStatus(prefix, message string) {
 	fmt.Printf("%s %s\n", prefix, message)
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apognu/wgctl/lib"
+)
+
+// daemonClient talks to a running wgctl daemon over its control socket
+type daemonClient struct {
+	http *http.Client
+}
+
+// newDaemonClient returns a client to the wgctl daemon, or nil if no daemon is listening on
+// the control socket, in which case commands should talk to netlink directly.
+func newDaemonClient() *daemonClient {
+	socket := lib.GetSocketPath()
+
+	conn, err := net.DialTimeout("unix", socket, 100*time.Millisecond)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+
+	return &daemonClient{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return new(net.Dialer).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Start brings up a tunnel through the daemon and returns its normalized name
+func (c *daemonClient) Start(instance string, noRoutes bool) (string, error) {
+	status := apiStatus{}
+	err := c.do(http.MethodPost, tunnelEndpoint(instance, "start"), apiStartRequest{NoRoutes: noRoutes}, &status)
+
+	return status.Name, err
+}
+
+// Stop tears down a tunnel through the daemon and returns its normalized name
+func (c *daemonClient) Stop(instance string) (string, error) {
+	status := apiStatus{}
+	err := c.do(http.MethodPost, tunnelEndpoint(instance, "stop"), nil, &status)
+
+	return status.Name, err
+}
+
+// Sync re-applies the configuration of a tunnel on its live device
+func (c *daemonClient) Sync(instance string) error {
+	return c.do(http.MethodPost, tunnelEndpoint(instance, "sync"), nil, &apiStatus{})
+}
+
+// Status returns the state of a tunnel, telling apart the tunnels that drifted from their
+// configuration if requested
+func (c *daemonClient) Status(instance string, drift bool) (string, error) {
+	endpoint := tunnelEndpoint(instance, "status")
+	if !drift {
+		endpoint = fmt.Sprintf("%s?drift=false", endpoint)
+	}
+
+	status := apiStatus{}
+	err := c.do(http.MethodGet, endpoint, nil, &status)
+
+	return status.State, err
+}
+
+// StatusAll returns the state of all tunnels known to the daemon, and of the WireGuard
+// interfaces without a configuration if requested
+func (c *daemonClient) StatusAll(allInterfaces, drift bool) ([]apiStatus, error) {
+	query := url.Values{}
+	if allInterfaces {
+		query.Set("all_interfaces", "true")
+	}
+	if !drift {
+		query.Set("drift", "false")
+	}
+
+	endpoint := fmt.Sprintf("%s/tunnels", apiPrefix)
+	if len(query) > 0 {
+		endpoint = fmt.Sprintf("%s?%s", endpoint, query.Encode())
+	}
+
+	statuses := make([]apiStatus, 0)
+	err := c.do(http.MethodGet, endpoint, nil, &statuses)
+
+	return statuses, err
+}
+
+// Info returns the description and live device of a tunnel
+func (c *daemonClient) Info(instance string) (*apiInfo, error) {
+	info := new(apiInfo)
+	err := c.do(http.MethodGet, tunnelEndpoint(instance, ""), nil, info)
+
+	return info, err
+}
+
+// SetPeer adds or changes a peer on a live tunnel
+func (c *daemonClient) SetPeer(instance string, props map[string]string, replace bool) error {
+	return c.do(http.MethodPost, tunnelEndpoint(instance, "peers"), apiPeerRequest{Peer: props, Replace: replace}, &apiStatus{})
+}
+
+// RemovePeer removes a peer and its routes from a live tunnel
+func (c *daemonClient) RemovePeer(instance, pubkey string) error {
+	endpoint := fmt.Sprintf("%s/%s", tunnelEndpoint(instance, "peers"), url.PathEscape(pubkey))
+
+	return c.do(http.MethodDelete, endpoint, nil, &apiStatus{})
+}
+
+func (c *daemonClient) do(method, endpoint string, body, out interface{}) error {
+	payload := new(bytes.Buffer)
+	if body != nil {
+		if err := json.NewEncoder(payload).Encode(body); err != nil {
+			return fmt.Errorf("could not encode request: %s", err.Error())
+		}
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("http://wgctl%s", endpoint), payload)
+	if err != nil {
+		return fmt.Errorf("could not create request: %s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach daemon: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := apiError{}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+			return fmt.Errorf("daemon returned status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("%s", apiErr.Error)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("could not decode daemon response: %s", err.Error())
+	}
+
+	return nil
+}
+
+// tunnelEndpoint builds the API path for an action on an instance. Configuration paths are
+// made absolute since the daemon does not share our working directory.
+func tunnelEndpoint(instance, action string) string {
+	if _, err := os.Stat(instance); err == nil {
+		if abs, err := filepath.Abs(instance); err == nil {
+			instance = abs
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s/tunnels/%s", apiPrefix, url.PathEscape(instance))
+	if len(action) > 0 {
+		endpoint = fmt.Sprintf("%s/%s", endpoint, action)
+	}
+
+	return endpoint
+}
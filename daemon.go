@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/apognu/wgctl/wireguard"
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	apiVersion = 1
+	apiPrefix  = "/v1"
+)
+
+type apiVersionResponse struct {
+	Version string `json:"version"`
+	API     int    `json:"api"`
+}
+
+type apiStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type apiInfo struct {
+	Description  string            `json:"description"`
+	Peers        map[string]string `json:"peers"`
+	PSKEncodings map[string]string `json:"psk_encodings"`
+	Device       *wgtypes.Device   `json:"device"`
+}
+
+type apiStartRequest struct {
+	NoRoutes bool `json:"no_routes"`
+}
+
+type apiPeerRequest struct {
+	Peer    map[string]string `json:"peer"`
+	Replace bool              `json:"replace"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// daemon manages the tunnels configured in the configuration directory and keeps their
+// parsed configuration in memory between requests.
+type daemon struct {
+	sync.Mutex
+	configs map[string]*lib.Config
+	locks   map[string]*sync.Mutex
+
+	// ctx bounds the operations run on tunnels, which must not be interrupted when the client
+	// that requested them goes away
+	ctx context.Context
+}
+
+func newDaemon(ctx context.Context) *daemon {
+	return &daemon{
+		configs: make(map[string]*lib.Config),
+		locks:   make(map[string]*sync.Mutex),
+		ctx:     ctx,
+	}
+}
+
+func runDaemon(socket string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newDaemon(ctx)
+	d.loadAll()
+
+	// A socket left behind by a daemon that did not exit cleanly can be replaced, but not the
+	// one of a running daemon
+	if conn, err := net.DialTimeout("unix", socket, 100*time.Millisecond); err == nil {
+		conn.Close()
+		logrus.Fatalf("a daemon is already listening on %s", socket)
+	}
+	os.Remove(socket)
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		logrus.Fatalf("could not listen on control socket: %s", err.Error())
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		logrus.Fatalf("could not set permissions on control socket: %s", err.Error())
+	}
+
+	srv := &http.Server{Handler: d}
+
+	sg := make(chan os.Signal, 1)
+	signal.Notify(sg, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sg
+		// Let running operations finish before their hooks are cancelled
+		srv.Shutdown(context.Background())
+		cancel()
+	}()
+
+	logrus.Infof("listening on %s", socket)
+
+	err = srv.Serve(l)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logrus.Fatalf("could not serve control API: %s", err.Error())
+	}
+
+	os.Remove(socket)
+}
+
+// lock serializes the operations on an instance, and returns the function releasing it
+func (d *daemon) lock(instance string) func() {
+	name := lib.GetInstanceFromArg(instance)
+
+	d.Lock()
+	l, ok := d.locks[name]
+	if !ok {
+		l = new(sync.Mutex)
+		d.locks[name] = l
+	}
+	d.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// loadAll parses all configurations found in the configuration directory, and replaces the
+// cached ones with them
+func (d *daemon) loadAll() {
+	paths, err := filepath.Glob(fmt.Sprintf("%s/*.yml", lib.GetConfigPath()))
+	if err != nil {
+		logrus.Warnf("could not enumerate your configurations: %s", err.Error())
+		return
+	}
+
+	configs := make(map[string]*lib.Config, len(paths))
+	for _, path := range paths {
+		config, err := lib.ParseConfig(path)
+		if err != nil {
+			logrus.Warnf("could not load '%s': %s", path, err.Error())
+			continue
+		}
+		configs[lib.GetInstanceFromArg(path)] = config
+	}
+
+	// Configurations that were removed or that cannot be parsed anymore are forgotten
+	d.Lock()
+	d.configs = configs
+	d.Unlock()
+}
+
+// load parses a configuration from disk and refreshes the cached copy
+func (d *daemon) load(instance string) (string, *lib.Config, error) {
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		return instance, nil, err
+	}
+	name := lib.GetInstanceFromArg(instance)
+
+	d.Lock()
+	d.configs[name] = config
+	d.Unlock()
+
+	return name, config, nil
+}
+
+// config returns the cached configuration for an instance, loading it if needed
+func (d *daemon) config(instance string) (string, *lib.Config, error) {
+	name := lib.GetInstanceFromArg(instance)
+
+	d.Lock()
+	config, ok := d.configs[name]
+	d.Unlock()
+
+	if ok && name == instance {
+		return name, config, nil
+	}
+	return d.load(instance)
+}
+
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), apiPrefix)
+	if path == r.URL.EscapedPath() {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unsupported API version"))
+		return
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case len(segments) == 1 && segments[0] == "version":
+		writeJSON(w, http.StatusOK, apiVersionResponse{Version: buildVersion, API: apiVersion})
+	case len(segments) == 1 && segments[0] == "tunnels" && r.Method == http.MethodGet:
+		d.handleStatusAll(w, r)
+	case len(segments) >= 2 && segments[0] == "tunnels":
+		instance, err := url.PathUnescape(segments[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid instance name: %s", err.Error()))
+			return
+		}
+
+		action := ""
+		if len(segments) > 2 {
+			action = segments[2]
+		}
+
+		if len(segments) == 4 && action == "peers" && r.Method == http.MethodDelete {
+			pubkey, err := url.PathUnescape(segments[3])
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid public key: %s", err.Error()))
+				return
+			}
+
+			d.handleRemovePeer(w, r, instance, pubkey)
+			return
+		}
+
+		d.handleTunnel(w, r, instance, action)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown endpoint '%s'", r.URL.Path))
+	}
+}
+
+func (d *daemon) handleStatusAll(w http.ResponseWriter, r *http.Request) {
+	d.loadAll()
+
+	d.Lock()
+	names := make([]string, 0, len(d.configs))
+	for name := range d.configs {
+		names = append(names, name)
+	}
+	d.Unlock()
+
+	drift := r.URL.Query().Get("drift") != "false"
+
+	statuses := make([]apiStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, apiStatus{Name: name, State: inspectTunnel(name, drift)})
+	}
+
+	if r.URL.Query().Get("all_interfaces") == "true" {
+		unmanaged, err := wg.Unmanaged(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		for _, name := range unmanaged {
+			statuses = append(statuses, apiStatus{Name: name, State: tunnelUnmanaged})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (d *daemon) handleTunnel(w http.ResponseWriter, r *http.Request, instance, action string) {
+	switch {
+	case r.Method == http.MethodGet && action == "":
+		name, config, err := d.config(instance)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusNotFound), err)
+			return
+		}
+		dev, _, err := wireguard.GetDevice(name)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), fmt.Errorf("could not retrieve device information: %w", err))
+			return
+		}
+
+		// Clients of the socket are not trusted with the private key of the tunnel
+		dev.PrivateKey = wgtypes.Key{}
+
+		writeJSON(w, http.StatusOK, apiInfo{Description: config.Description, Peers: peerDescriptions(config), PSKEncodings: pskEncodings(config), Device: dev})
+
+	case r.Method == http.MethodGet && action == "status":
+		name := lib.GetInstanceFromArg(instance)
+
+		writeJSON(w, http.StatusOK, apiStatus{Name: name, State: inspectTunnel(instance, r.URL.Query().Get("drift") != "false")})
+
+	case r.Method == http.MethodPost && action == "start":
+		req := apiStartRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		defer d.lock(instance)()
+
+		name, config, err := d.load(instance)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err := wg.StartConfig(d.ctx, name, config, req.NoRoutes); err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, apiStatus{Name: name, State: tunnelState(name)})
+
+	case r.Method == http.MethodPost && action == "stop":
+		defer d.lock(instance)()
+
+		// Hooks are taken from the current configuration, unless it cannot be used anymore
+		name, config, err := d.load(instance)
+		if err != nil {
+			d.Lock()
+			cached, ok := d.configs[lib.GetInstanceFromArg(instance)]
+			d.Unlock()
+
+			if !ok {
+				writeError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+
+			logrus.Warnf("could not reload '%s', using its previous configuration: %s", instance, err.Error())
+			name, config = lib.GetInstanceFromArg(instance), cached
+		}
+
+		if err := wg.StopConfig(d.ctx, name, config); err != nil && !errors.Is(err, wireguard.ErrDeviceNotFound) {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, apiStatus{Name: name, State: tunnelState(name)})
+
+	case r.Method == http.MethodPost && action == "sync":
+		defer d.lock(instance)()
+
+		name, config, err := d.load(instance)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err := wireguard.SyncDevice(name, config); err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, apiStatus{Name: name, State: tunnelState(name)})
+
+	case r.Method == http.MethodPost && action == "peers":
+		req := apiPeerRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		p, err := parsePeerProps(req.Peer)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		defer d.lock(instance)()
+
+		name := lib.GetInstanceFromArg(instance)
+		if err := wg.SetPeer(d.ctx, name, p, req.Replace); err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, apiStatus{Name: name, State: tunnelState(name)})
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown endpoint '%s'", r.URL.Path))
+	}
+}
+
+func (d *daemon) handleRemovePeer(w http.ResponseWriter, r *http.Request, instance, pubkey string) {
+	key, err := parsePublicKey(pubkey)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	defer d.lock(instance)()
+
+	name := lib.GetInstanceFromArg(instance)
+	if err := wg.RemovePeer(d.ctx, name, key); err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiStatus{Name: name, State: tunnelState(name)})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(body)
+}
+
+// errorStatus returns the HTTP status matching a typed error, or the given fallback
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, wireguard.ErrDeviceNotFound), errors.Is(err, wireguard.ErrPeerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, wireguard.ErrNotWireGuard):
+		return http.StatusConflict
+	case errors.Is(err, lib.ErrConfigInvalid):
+		return http.StatusUnprocessableEntity
+	}
+	return fallback
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, apiError{Error: err.Error()})
+}
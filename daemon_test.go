@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setUpDaemonTest(t *testing.T) (*daemon, string) {
+	dir, err := ioutil.TempDir("", "wgctl")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err.Error())
+	}
+
+	os.Setenv("WGCTL_CONFIG_PATH", dir)
+	os.Setenv("WGCTL_SOCKET", filepath.Join(dir, "wgctl.sock"))
+
+	return newDaemon(context.Background()), dir
+}
+
+func tearDownDaemonTest(dir string) {
+	os.Unsetenv("WGCTL_CONFIG_PATH")
+	os.Unsetenv("WGCTL_SOCKET")
+	os.RemoveAll(dir)
+}
+
+func Test_DaemonRouting(t *testing.T) {
+	d, dir := setUpDaemonTest(t)
+	defer tearDownDaemonTest(dir)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		err    string
+	}{
+		{http.MethodGet, "/v2/version", "", http.StatusNotFound, "unsupported API version"},
+		{http.MethodGet, "/v1/unknown", "", http.StatusNotFound, "unknown endpoint"},
+		{http.MethodGet, "/v1/tunnels", "", http.StatusOK, ""},
+		{http.MethodPost, "/v1/tunnels/vpn1/unknown", "", http.StatusNotFound, "unknown endpoint"},
+		{http.MethodPost, "/v1/tunnels/vpn1/start", "{", http.StatusBadRequest, ""},
+		{http.MethodPost, "/v1/tunnels/vpn1/start", "{}", http.StatusUnprocessableEntity, "could not read configuration file"},
+		{http.MethodPost, "/v1/tunnels/vpn1/stop", "", http.StatusUnprocessableEntity, "could not read configuration file"},
+		{http.MethodPost, "/v1/tunnels/vpn1/peers", `{"peer":{"pubkey":"invalid"}}`, http.StatusBadRequest, "could not decode public key"},
+		{http.MethodDelete, "/v1/tunnels/vpn1/peers/invalid", "", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+		assert.Equal(t, tt.code, w.Code, "%s %s", tt.method, tt.path)
+
+		if len(tt.err) > 0 {
+			apiErr := apiError{}
+			json.NewDecoder(w.Body).Decode(&apiErr)
+
+			assert.Contains(t, apiErr.Error, tt.err, "%s %s", tt.method, tt.path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/version", nil))
+
+	version := apiVersionResponse{}
+	json.NewDecoder(w.Body).Decode(&version)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, apiVersion, version.API)
+}
+
+func Test_DaemonClient(t *testing.T) {
+	d, dir := setUpDaemonTest(t)
+	defer tearDownDaemonTest(dir)
+
+	assert.Nil(t, newDaemonClient())
+
+	l, err := net.Listen("unix", filepath.Join(dir, "wgctl.sock"))
+	if err != nil {
+		t.Fatalf("could not listen on control socket: %s", err.Error())
+	}
+
+	srv := httptest.NewUnstartedServer(d)
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	client := newDaemonClient()
+	assert.NotNil(t, client)
+
+	_, err = client.Start("vpn1", false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not read configuration file")
+
+	statuses, err := client.StatusAll(false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(statuses))
+
+	statuses, err = client.StatusAll(true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(statuses))
+
+	assert.NotNil(t, client.RemovePeer("vpn1", "invalid"))
+}
+
+func Test_TunnelEndpoint(t *testing.T) {
+	assert.Equal(t, "/v1/tunnels/vpn1/start", tunnelEndpoint("vpn1", "start"))
+	assert.Equal(t, "/v1/tunnels/vpn1", tunnelEndpoint("vpn1", ""))
+
+	file, _ := ioutil.TempFile("", "wgctl")
+	defer os.Remove(file.Name())
+
+	assert.Equal(t, "/v1/tunnels/"+strings.Replace(file.Name(), "/", "%2F", -1)+"/stop", tunnelEndpoint(file.Name(), "stop"))
+}
+
+func Test_DaemonLoadAll(t *testing.T) {
+	d, dir := setUpDaemonTest(t)
+	defer tearDownDaemonTest(dir)
+
+	path := filepath.Join(dir, "vpn1.yml")
+	ioutil.WriteFile(path, []byte(`
+private_key: {value: '7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4='}
+peers:
+  - listen_port: 23456
+    public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+`), 0600)
+
+	d.loadAll()
+	assert.Len(t, d.configs, 1)
+
+	ioutil.WriteFile(path, []byte("peers: [\n"), 0600)
+	d.loadAll()
+	assert.Len(t, d.configs, 0)
+
+	os.Remove(path)
+	d.loadAll()
+	assert.Len(t, d.configs, 0)
+}
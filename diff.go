@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+)
+
+var hunkColor = color.New(color.FgCyan)
+
+// diffTunnel prints the differences between the configuration of a tunnel and its live state,
+// and exits with an error status if there are any.
+func diffTunnel(instance string) {
+	drift, err := wg.Diff(context.Background(), instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	name := lib.GetInstanceFromArg(instance)
+	lines := drift.Diff(lib.GetConfigFile(instance), fmt.Sprintf("%s (running)", name))
+
+	if len(lines) == 0 {
+		Up("tunnel '%s' matches its configuration", name)
+		return
+	}
+
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			attrKeyColor.Println(line)
+		case strings.HasPrefix(line, "@@"):
+			hunkColor.Println(line)
+		case strings.HasPrefix(line, "-"):
+			errColor.Println(line)
+		case strings.HasPrefix(line, "+"):
+			okColor.Println(line)
+		default:
+			fmt.Println(line)
+		}
+	}
+
+	os.Exit(1)
+}
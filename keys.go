@@ -2,10 +2,12 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/apognu/wgctl/lib"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -13,37 +15,283 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func generateKey() {
+// generateKey prints a new private key, or writes it to a file only readable by its owner and
+// prints the matching public key
+func generateKey(encrypt bool, out string) {
 	k, err := lib.GeneratePrivateKey()
 	if err != nil {
 		logrus.Fatalf("could not generate private key: %s", err.Error())
 	}
 
-	fmt.Println(k.String())
+	data := []byte(k.String() + "\n")
+	if encrypt {
+		passphrase, err := newPassphrase("WGCTL_PASSPHRASE")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		data, err = lib.EncryptKey(k.Data[:], passphrase)
+		if err != nil {
+			logrus.Fatalf("could not encrypt private key: %s", err.Error())
+		}
+	}
+
+	if len(out) == 0 {
+		fmt.Print(string(data))
+		return
+	}
+
+	if err := lib.WriteKeyFile(out, data); err != nil {
+		logrus.Fatal(err)
+	}
+
+	pubkey := lib.ComputePublicKey(k.Data[:])
+
+	fmt.Println(pubkey.String())
 }
 
-func generatePublicKey() {
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
+func decryptKey(path string) {
+	k, err := lib.ReadPrivateKey(path)
+	if err != nil {
+		logrus.Fatalf("could not read private key: %s", err.Error())
+	}
+
+	fmt.Println(base64.StdEncoding.EncodeToString(k[:]))
+}
 
-	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(scanner.Text()))
+// reencryptKey encrypts a key file with a new passphrase, in place, encrypting it for the first
+// time if it was stored in plaintext
+func reencryptKey(path string) {
+	k, err := lib.ReadPrivateKey(path)
 	if err != nil {
-		logrus.Fatalf("could not read private key from stdin: %s", err.Error())
+		logrus.Fatalf("could not read private key: %s", err.Error())
+	}
+
+	passphrase, err := newPassphrase("WGCTL_NEW_PASSPHRASE")
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	data, err := lib.EncryptKey(k[:], passphrase)
+	if err != nil {
+		logrus.Fatalf("could not encrypt private key: %s", err.Error())
+	}
+
+	if err := lib.WriteFileAtomic(path, data, 0600); err != nil {
+		logrus.Fatal(err)
 	}
-	if len(b) != wgtypes.KeyLen {
-		logrus.Fatalf("the key read from stdin is of an invalid size")
+}
+
+// generatePublicKey prints the public key matching a private key read from a file, from the
+// configuration of an instance or from stdin
+func generatePublicKey(file, instance string) {
+	var priv [wgtypes.KeyLen]byte
+
+	switch {
+	case len(file) > 0:
+		k, err := lib.ReadPrivateKey(file)
+		if err != nil {
+			logrus.Fatalf("could not read private key: %s", err.Error())
+		}
+		priv = k
+	case len(instance) > 0:
+		config, err := lib.ParseConfig(instance)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		priv = config.PrivateKey.Bytes()
+	default:
+		b, err := base64.StdEncoding.DecodeString(readStdinLine())
+		if err != nil {
+			logrus.Fatalf("could not read private key from stdin: %s", err.Error())
+		}
+		if len(b) != wgtypes.KeyLen {
+			logrus.Fatalf("the key read from stdin is of an invalid size")
+		}
+		copy(priv[:], b)
 	}
 
-	k := lib.ComputePublicKey(b)
+	k := lib.ComputePublicKey(priv[:])
 
 	fmt.Println(k.String())
 }
 
+// inspectKey prints what a key, given as an argument or read from stdin, can be used as
+func inspectKey(key string) {
+	fromArg := len(key) > 0
+	if !fromArg {
+		key = readStdinLine()
+	}
+
+	info, err := lib.InspectKey(key)
+	if err != nil {
+		logrus.Fatalf("could not decode key: %s", err.Error())
+	}
+
+	PrintSection(0, "key", info.Kind, tunnelColor)
+	PrintAttr(1, "encoding", info.Encoding, true)
+
+	switch info.Kind {
+	case lib.KeyKindMaybePrivate:
+		pubkey := lib.ComputePublicKey(info.Data[:])
+		PrintAttr(1, "clamped", "yes, could be a Curve25519 private key", true)
+		PrintAttr(1, "public key", pubkey.String(), true)
+
+		// Command-line arguments are visible to other users and kept in the shell history
+		if fromArg {
+			logrus.Warn("private keys should be given on stdin rather than as an argument")
+		}
+	case lib.KeyKindPublicOrPSK:
+		PrintAttr(1, "clamped", "no, not a valid Curve25519 private key", true)
+	}
+}
+
+func readStdinLine() string {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+
+	return strings.TrimSpace(scanner.Text())
+}
+
 func generatePSK() {
 	k, err := lib.GeneratePSK()
 	if err != nil {
 		logrus.Fatalf("could not generate private key: %s", err.Error())
 	}
 
+	fmt.Println(k.Format(lib.PSKEncoding))
+}
+
+// rotateKey generates a new private key for a tunnel and, if requested, new preshared keys for
+// all its peers, saves them to its configuration and applies them to the live tunnel
+func rotateKey(instance string, psk bool) {
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	f, err := lib.LoadConfigFile(instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	keyPath, pubkey, err := f.RotateKey(config, time.Now())
+	if err != nil {
+		logrus.Fatalf("could not rotate private key: %s", err.Error())
+	}
+	if passphrase, ok := passphrases[config.PrivateKey.Path]; ok {
+		passphrases[keyPath] = passphrase
+	}
+	if psk {
+		skipped, err := f.RotatePresharedKeys(config)
+		if err != nil {
+			os.Remove(keyPath)
+			logrus.Fatalf("could not rotate preshared keys: %s", err.Error())
+		}
+		for _, ierr := range skipped {
+			logrus.Warnf("the preshared key of peer '%s' was not rotated since it is defined in '%s'", ierr.Peer, ierr.File)
+		}
+	}
+
+	newConfig, err := f.Config()
+	if err == nil {
+		err = f.Save()
+	}
+	if err != nil {
+		os.Remove(keyPath)
+		logrus.Fatal(err)
+	}
+
+	if len(keyPath) > 0 {
+		logrus.Infof("new private key written to '%s'", keyPath)
+		logrus.Warnf("the previous private key '%s' is kept, remove it once the peers use the new public key", config.PrivateKey.Path)
+	}
+	if psk {
+		logrus.Warn("handshakes with the peers fail until they are given their new preshared key")
+	}
+
+	if name := lib.GetInstanceFromArg(instance); tunnelState(name) == tunnelUp {
+		if client := newDaemonClient(); client != nil {
+			err = client.Sync(instance)
+		} else {
+			err = wg.Apply(context.Background(), name, newConfig)
+		}
+		if err != nil {
+			logrus.Fatalf("could not apply new keys to live tunnel: %s", err.Error())
+		}
+	}
+
+	fmt.Println(pubkey.String())
+}
+
+// vanityProgress describes the progress of a vanity search, with the time it should still take
+// on average
+func vanityProgress(attempts uint64, expected float64, elapsed time.Duration) string {
+	if attempts == 0 || elapsed <= 0 {
+		return fmt.Sprintf("%d keys tried", attempts)
+	}
+	rate := float64(attempts) / elapsed.Seconds()
+
+	remaining := expected - float64(attempts)
+	if remaining <= 0 {
+		return fmt.Sprintf("%d keys tried, %.0f keys/s, taking longer than average", attempts, rate)
+	}
+
+	eta := time.Duration(remaining / rate * float64(time.Second))
+
+	return fmt.Sprintf("%d keys tried, %.0f keys/s, %s left on average", attempts, rate, eta.Round(time.Second))
+}
+
+// vanityKey searches for a private key whose public key starts with a prefix, on all CPU cores,
+// and prints it like generateKey does
+func vanityKey(prefix string, ignoreCase bool, workers int, out string) {
+	search, err := lib.NewVanitySearch(prefix, ignoreCase)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		start := time.Now()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fmt.Fprintf(os.Stderr, "\r%s   ", vanityProgress(search.Attempts(), search.Expected(), time.Since(start)))
+			}
+		}
+	}()
+
+	k, err := search.Run(context.Background(), workers)
+
+	// Stop the progress line before printing anything else
+	close(done)
+	<-stopped
+
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	pubkey := lib.ComputePublicKey(k.Data[:])
+
+	if len(out) > 0 {
+		if err := lib.WriteKeyFile(out, []byte(k.String()+"\n")); err != nil {
+			logrus.Fatal(err)
+		}
+
+		fmt.Println(pubkey.String())
+		return
+	}
+
+	logrus.Infof("found public key %s after %d attempts", pubkey.String(), search.Attempts())
+
 	fmt.Println(k.String())
 }
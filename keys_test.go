@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_VanityProgress(t *testing.T) {
+	assert.Equal(t, "0 keys tried", vanityProgress(0, 1000, time.Second))
+	assert.Equal(t, "10 keys tried", vanityProgress(10, 1000, 0))
+	assert.Equal(t, "100 keys tried, 100 keys/s, 9s left on average", vanityProgress(100, 1000, time.Second))
+	assert.Equal(t, "2000 keys tried, 1000 keys/s, taking longer than average", vanityProgress(2000, 1000, 2*time.Second))
+}
@@ -6,15 +6,19 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	yaml "gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
 )
 
 // IPNet is an unmarshalable version of net.IPNet
@@ -23,12 +27,18 @@ type IPNet net.IPNet
 // UDPAddr is an unmarshalable version of net.UDPAddr
 type UDPAddr net.UDPAddr
 
-// PrivateKey is an unmarshalable file path
+// PrivateKey is an unmarshalable private key, read from the file path or the secret reference
+// (see ResolveSecret) kept in Path, or given inline if Path is empty
 type PrivateKey struct {
 	Path string
 	Data [wgtypes.KeyLen]byte
 }
 
+// Inline returns whether a private key was given in the configuration itself
+func (k *PrivateKey) Inline() bool {
+	return len(k.Path) == 0 && k.Data != EmptyPSK
+}
+
 // NewPrivateKey retirns a PrivateKey from a []byte
 func NewPrivateKey(bk []byte) PrivateKey {
 	k := new([wgtypes.KeyLen]byte)
@@ -91,6 +101,21 @@ func (k *PresharedKey) String() string {
 	return hex.EncodeToString([]byte(*k))
 }
 
+// PSKEncoding is the encoding preshared keys are written in, unless they were configured in
+// another one (see KeyEncodingBase64 and KeyEncodingHex)
+var PSKEncoding = KeyEncodingHex
+
+// Format returns the representation of a preshared key in the given encoding
+func (k *PresharedKey) Format(encoding string) string {
+	if k == nil {
+		return ""
+	}
+	if encoding == KeyEncodingBase64 {
+		return base64.StdEncoding.EncodeToString([]byte(*k))
+	}
+	return hex.EncodeToString([]byte(*k))
+}
+
 // IPMask represents an IP address and its subnet mask, to be assigned to an interface
 type IPMask struct {
 	IP   net.IP
@@ -106,8 +131,27 @@ func (ip IPMask) String() string {
 type Config struct {
 	Description string     `yaml:"description"`
 	PrivateKey  PrivateKey `yaml:"private_key"`
+	Autostart   bool       `yaml:"autostart,omitempty"`
+	After       []string   `yaml:"after,omitempty"`
+	Pool        []IPNet    `yaml:"pool,omitempty"`
 	Self        *Peer      `yaml:"-"`
 	Peers       []*Peer    `yaml:"peers"`
+
+	// Defaults and Groups hold peer properties shared by several peers (see Check)
+	Defaults *PeerDefaults           `yaml:"defaults,omitempty"`
+	Groups   map[string]PeerDefaults `yaml:"groups,omitempty"`
+}
+
+// PeerDefaults represents the properties inherited by peers which do not set them, either from
+// the defaults of a configuration or from the group they belong to
+type PeerDefaults struct {
+	PresharedKey      *PresharedKey `yaml:"preshared_key,omitempty"`
+	Endpoint          *UDPAddr      `yaml:"endpoint,omitempty"`
+	KeepaliveInterval time.Duration `yaml:"keepalive_interval,omitempty"`
+
+	// AllowedIPS is not inherited, since an allowed IP can only be routed to a single peer. It
+	// is only decoded for Check to refuse it.
+	AllowedIPS []IPNet `yaml:"allowed_ips,omitempty"`
 }
 
 // Peer represents a YAML-encodable configuration for a WireGuard peer
@@ -124,41 +168,151 @@ type Peer struct {
 	PostUp            [][]string    `yaml:"post_up,omitempty"`
 	PreDown           [][]string    `yaml:"pre_down,omitempty"`
 	SetUpRoutes       *bool         `yaml:"routes,omitempty"`
+	Group             string        `yaml:"group,omitempty"`
+
+	// PresharedKeyEncoding is the encoding the preshared key was configured in, if it was
+	// given inline
+	PresharedKeyEncoding string `yaml:"-"`
+	// PresharedKeyRef is the secret reference the preshared key was read from, if any, which
+	// is written instead of the key itself
+	PresharedKeyRef string `yaml:"-"`
 }
 
-// ParseConfig unmarshals a Config from a YAML string
+// ParseConfig unmarshals the Config of an instance, merged with the files it includes and its
+// fragments (see ExpandIncludes), and with its values expanded (see ExpandValues)
 func ParseConfig(instance string) (*Config, error) {
-	path := fmt.Sprintf("%s/%s.yml", GetConfigPath(), instance)
-	if _, err := os.Stat(instance); err == nil {
-		path = instance
+	path := GetConfigFile(instance)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read configuration file: %w", err)
 	}
 
-	config, err := os.Open(path)
+	return parseConfig(path, data)
+}
+
+// ExpandConfigFile returns the YAML configuration data read from path, merged with the files it
+// includes and with its values expanded, as it is parsed
+func ExpandConfigFile(path string, data []byte) ([]byte, error) {
+	data, err := ExpandIncludes(path, data)
 	if err != nil {
-		return nil, fmt.Errorf("could not read configuration file: %s", err.Error())
+		return nil, err
 	}
 
-	return ParseConfigReader(config)
+	return ExpandValues(data, NewTemplateContext(path))
+}
+
+func parseConfig(path string, data []byte) (*Config, error) {
+	return parseConfigData(path, data, NewTemplateContext(path))
 }
 
-// ParseConfigReader unmarshals a Config from an io.Reader mapped to a YAML file
+// ParseConfigReader unmarshals a Config from an io.Reader mapped to a YAML file, with its values
+// expanded (see ExpandValues)
+// If the configuration cannot be used, the returned error matches ErrConfigInvalid, and wraps
+// a ConfigErrors listing all invalid values if there are any.
 func ParseConfigReader(config io.Reader) (*Config, error) {
+	data, err := ioutil.ReadAll(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not read configuration file: %w", err)
+	}
+
+	return parseConfigData("", data, NewTemplateContext(""))
+}
+
+// parseConfigData unmarshals a Config from YAML data, merged with the files it includes if it
+// was read from path
+func parseConfigData(path string, data []byte, ctx TemplateContext) (*Config, error) {
+	// Merging and expansion rewrite the data, but the nodes of the document keep the position of
+	// the values in the file they were read from, which is where invalid values are reported
+	doc := new(yaml3.Node)
+	if err := yaml3.Unmarshal(data, doc); err != nil {
+		// Let the decoder report the error
+		doc = nil
+	}
+
+	var files nodeFiles
+	if doc != nil {
+		if len(path) > 0 {
+			var err error
+			if files, err = mergeDocument(path, doc); err != nil {
+				return nil, invalidConfigError{err}
+			}
+		}
+
+		expanded, err := expandNode(doc, ctx)
+		if err != nil {
+			return nil, invalidConfigError{err}
+		}
+
+		if files != nil || expanded {
+			if data, err = encodeDocument(doc); err != nil {
+				return nil, invalidConfigError{err}
+			}
+		}
+	}
+
 	c := new(Config)
-	err := yaml.NewDecoder(config).Decode(c)
+	err := yaml.NewDecoder(bytes.NewReader(data)).Decode(c)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse configuration file: %s", err.Error())
+		// Decode every value separately to report all the invalid ones at once
+		if doc != nil {
+			if errs := collectErrors(doc, path, files, reflect.TypeOf(Config{}), err); len(errs) > 0 {
+				return nil, invalidConfigError{fmt.Errorf("could not parse configuration file: %w", errs)}
+			}
+		}
+		return nil, invalidConfigError{fmt.Errorf("could not parse configuration file: %w", err)}
 	}
 
+	presharedKeyEncodings(c, data)
+
 	err = c.Check()
 	if err != nil {
-		return nil, fmt.Errorf("configuration check failed: %s", err.Error())
+		return nil, invalidConfigError{fmt.Errorf("configuration check failed: %w", err)}
 	}
 
 	return c, nil
 }
 
+// presharedKeyEncodings records the encoding of the preshared keys written in a configuration,
+// or the secret reference they were read from, so that they can be written back the same way
+func presharedKeyEncodings(c *Config, data []byte) {
+	f, err := ParseConfigFile("", data)
+	if err != nil {
+		return
+	}
+
+	peers := mappingValue(f.doc.Content[0], "peers")
+	if peers == nil || len(peers.Content) != len(c.Peers) {
+		return
+	}
+
+	for idx, node := range peers.Content {
+		psk := mappingValue(node, "preshared_key")
+		if psk == nil || c.Peers[idx].PresharedKey == nil {
+			continue
+		}
+		if IsSecretRef(psk.Value) {
+			c.Peers[idx].PresharedKeyRef = psk.Value
+			continue
+		}
+		if info, err := InspectKey(psk.Value); err == nil {
+			c.Peers[idx].PresharedKeyEncoding = info.Encoding
+		}
+	}
+}
+
+// PresharedKeyString returns the preshared key of a peer in the encoding it was configured in,
+// or in PSKEncoding
+func (p *Peer) PresharedKeyString() string {
+	if len(p.PresharedKeyEncoding) > 0 {
+		return p.PresharedKey.Format(p.PresharedKeyEncoding)
+	}
+	return p.PresharedKey.Format(PSKEncoding)
+}
+
 // Check verifies that all mandatory config directive have been given for a Config
-// It also sets default values for some fields
+// It also sets default values for some fields, and resolves the properties peers inherit: those
+// of their group first, then, except for the self peer, those of the defaults section
 func (c *Config) Check() error {
 	if c.PrivateKey.Data == EmptyPSK {
 		return fmt.Errorf("'private_key' must be provided")
@@ -190,9 +344,55 @@ func (c *Config) Check() error {
 		return fmt.Errorf("'listen_port' must be provided")
 	}
 
+	if c.Defaults != nil && len(c.Defaults.AllowedIPS) > 0 {
+		return fmt.Errorf("'allowed_ips' cannot be set in 'defaults', since an allowed IP can only be routed to a single peer")
+	}
+	names := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(c.Groups[name].AllowedIPS) > 0 {
+			return fmt.Errorf("'allowed_ips' cannot be set in group '%s', since an allowed IP can only be routed to a single peer", name)
+		}
+	}
+
+	for _, p := range append([]*Peer{c.Self}, c.Peers...) {
+		if len(p.Group) == 0 {
+			continue
+		}
+		group, ok := c.Groups[p.Group]
+		if !ok {
+			return fmt.Errorf("peer '%s' belongs to unknown group '%s'", p.PublicKey.String(), p.Group)
+		}
+		p.inherit(&group)
+	}
+	if c.Defaults != nil {
+		for _, p := range c.Peers {
+			p.inherit(c.Defaults)
+		}
+	}
+
 	return nil
 }
 
+// inherit sets the properties of a peer which were not configured from defaults. Each peer gets
+// its own copy of them, so that changing the properties of a peer leaves the others alone.
+func (p *Peer) inherit(d *PeerDefaults) {
+	if p.PresharedKey == nil && d.PresharedKey != nil {
+		psk := append(PresharedKey(nil), *d.PresharedKey...)
+		p.PresharedKey = &psk
+	}
+	if p.Endpoint == nil && d.Endpoint != nil {
+		endpoint := *d.Endpoint
+		p.Endpoint = &endpoint
+	}
+	if p.KeepaliveInterval == 0 {
+		p.KeepaliveInterval = d.KeepaliveInterval
+	}
+}
+
 // GetPeer finds a peer in a Config from its public key string representation
 func (c *Config) GetPeer(publicKey string) *Peer {
 	for _, p := range c.Peers {
@@ -203,6 +403,65 @@ func (c *Config) GetPeer(publicKey string) *Peer {
 	return nil
 }
 
+// FindPeer finds a peer in a Config from its public key or its description
+func (c *Config) FindPeer(match string) (*Peer, error) {
+	if p := c.GetPeer(match); p != nil {
+		return p, nil
+	}
+
+	var found *Peer
+	for _, p := range c.Peers {
+		if p.Description == match {
+			if found != nil {
+				return nil, fmt.Errorf("several peers are described as '%s', use a public key", match)
+			}
+			found = p
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("could not find peer '%s'", match)
+	}
+
+	return found, nil
+}
+
+// PeerDescriptions maps the public keys of the peers of an instance to their descriptions. The
+// secrets the configuration references are not resolved, so that it can be used where they
+// could not be prompted for.
+func PeerDescriptions(instance string) (map[string]string, error) {
+	path := GetConfigFile(instance)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read configuration file: %w", err)
+	}
+
+	data, err = ExpandConfigFile(path, data)
+	if err != nil {
+		return nil, invalidConfigError{err}
+	}
+
+	doc := struct {
+		Peers []struct {
+			Description string `yaml:"description"`
+			PublicKey   string `yaml:"public_key"`
+		} `yaml:"peers"`
+	}{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, invalidConfigError{fmt.Errorf("could not parse configuration file: %w", err)}
+	}
+
+	descriptions := make(map[string]string)
+	for _, p := range doc.Peers {
+		if len(p.Description) > 0 {
+			descriptions[p.PublicKey] = p.Description
+		}
+	}
+
+	return descriptions, nil
+}
+
 // GetConfigPath returns the directory where the configuration files should be looked for
 // This path can be overridden by setting the WGCTL_CONFIG_PATH environment variable
 func GetConfigPath() string {
@@ -212,6 +471,24 @@ func GetConfigPath() string {
 	return "/etc/wireguard"
 }
 
+// GetConfigFile returns the path to the configuration file of an instance, which can either
+// be a name or a path to an existing file
+func GetConfigFile(instance string) string {
+	if _, err := os.Stat(instance); err == nil {
+		return instance
+	}
+	return fmt.Sprintf("%s/%s.yml", GetConfigPath(), instance)
+}
+
+// GetSocketPath returns the path to the control socket of the wgctl daemon
+// This path can be overridden by setting the WGCTL_SOCKET environment variable
+func GetSocketPath() string {
+	if len(strings.TrimSpace(os.Getenv("WGCTL_SOCKET"))) > 0 {
+		return strings.TrimSpace(os.Getenv("WGCTL_SOCKET"))
+	}
+	return "/run/wgctl.sock"
+}
+
 // GetInstanceFromArg returns the normalized name of a WireGuard tunnel instance (and interface)
 func GetInstanceFromArg(path string) string {
 	if _, err := os.Stat(path); err == nil {
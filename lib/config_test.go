@@ -7,6 +7,7 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,6 +17,8 @@ import (
 const fullConfigYAML = `
 description: Lorem ipsum dolor sit amet
 private_key: /tmp/testing.key
+autostart: true
+after: [uplink]
 peers:
   - description: 'Server'
     address: 1.2.3.4/24
@@ -40,6 +43,27 @@ peers:
     keepalive_interval: 10
 `
 
+const groupedConfigYAML = `
+private_key: /tmp/testing.key
+defaults:
+  keepalive_interval: 25s
+groups:
+  branch-offices:
+    preshared_key: 4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1
+    endpoint: 4.3.2.1:45000
+peers:
+  - listen_port: 23456
+    public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+  - description: 'Peer #1'
+    public_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+    group: branch-offices
+  - description: 'Peer #2'
+    public_key: 4X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+    group: branch-offices
+    keepalive_interval: 10s
+    endpoint: 4.3.2.1:45001
+`
+
 const fullIPv6ConfigYAML = `
 description: Lorem ipsum dolor sit amet
 private_key: /tmp/testing.key
@@ -111,6 +135,8 @@ func Test_ParseFullConfig(t *testing.T) {
 	addr, _, _ := net.ParseCIDR("1.2.3.4/24")
 
 	assert.Equal(t, "Lorem ipsum dolor sit amet", c.Description)
+	assert.Equal(t, true, c.Autostart)
+	assert.Equal(t, []string{"uplink"}, c.After)
 	assert.Equal(t, addr, c.Self.Address.IP)
 	assert.Equal(t, 24, c.Self.Address.Mask)
 	assert.Equal(t, 23456, c.Self.ListenPort)
@@ -203,6 +229,40 @@ func Test_ParseConfigWithEmptyPeerKey(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func Test_ParseConfigWithGroups(t *testing.T) {
+	createPKey(t)
+	c, err := ParseConfigReader(bytes.NewReader([]byte(groupedConfigYAML)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), c.Self.KeepaliveInterval)
+	assert.Nil(t, c.Self.PresharedKey)
+
+	assert.Equal(t, 25*time.Second, c.Peers[0].KeepaliveInterval)
+	assert.Equal(t, "4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1", c.Peers[0].PresharedKey.String())
+	assert.Equal(t, 45000, c.Peers[0].Endpoint.Port)
+
+	assert.Equal(t, 10*time.Second, c.Peers[1].KeepaliveInterval)
+	assert.Equal(t, 45001, c.Peers[1].Endpoint.Port)
+	assert.Equal(t, c.Peers[0].PresharedKey, c.Peers[1].PresharedKey)
+
+	// Inherited keys are copied to each peer
+	(*c.Peers[0].PresharedKey)[0] ^= 0xff
+	assert.Equal(t, "4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1", c.Peers[1].PresharedKey.String())
+
+	_, err = ParseConfigReader(bytes.NewReader([]byte(strings.Replace(groupedConfigYAML, "  keepalive_interval: 25s\n", "  keepalive_interval: 25s\n  allowed_ips: [10.0.0.0/24]\n", 1))))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "'allowed_ips' cannot be set in 'defaults'")
+
+	_, err = ParseConfigReader(bytes.NewReader([]byte(strings.Replace(groupedConfigYAML, "    endpoint: 4.3.2.1:45000\n", "    endpoint: 4.3.2.1:45000\n    allowed_ips: [10.0.0.0/24]\n", 1))))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "'allowed_ips' cannot be set in group 'branch-offices'")
+
+	_, err = ParseConfigReader(bytes.NewReader([]byte(strings.Replace(groupedConfigYAML, "group: branch-offices\n    keepalive", "group: unknown\n    keepalive", 1))))
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unknown group 'unknown'")
+}
+
 func Test_CheckConfig(t *testing.T) {
 	c := &Config{}
 	assert.NotEqual(t, nil, c.Check())
@@ -260,6 +320,27 @@ func Test_GetPeer(t *testing.T) {
 	assert.Equal(t, "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", p.PublicKey.String())
 }
 
+func Test_FindPeer(t *testing.T) {
+	createPKey(t)
+	c, err := ParseConfigReader(bytes.NewReader([]byte(fullConfigYAML)))
+	assert.Nil(t, err)
+
+	p, err := c.FindPeer("Peer #2")
+	assert.Nil(t, err)
+	assert.Equal(t, "4X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", p.PublicKey.String())
+
+	p, err = c.FindPeer("7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=")
+	assert.Nil(t, err)
+	assert.Equal(t, "Peer #1", p.Description)
+
+	_, err = c.FindPeer("Peer #3")
+	assert.NotNil(t, err)
+
+	c.Peers[1].Description = "Peer #1"
+	_, err = c.FindPeer("Peer #1")
+	assert.NotNil(t, err)
+}
+
 func Test_GetInstanceFromArg(t *testing.T) {
 	assert.Equal(t, "instance", GetInstanceFromArg("instance"))
 	assert.Equal(t, "hosts", GetInstanceFromArg("/etc/hosts"))
@@ -273,6 +354,16 @@ func Test_GetConfigPath(t *testing.T) {
 	assert.Equal(t, "/my/wireguard/config", GetConfigPath())
 }
 
+func Test_GetSocketPath(t *testing.T) {
+	os.Setenv("WGCTL_SOCKET", "")
+	assert.Equal(t, "/run/wgctl.sock", GetSocketPath())
+
+	os.Setenv("WGCTL_SOCKET", "/tmp/wgctl.sock")
+	assert.Equal(t, "/tmp/wgctl.sock", GetSocketPath())
+
+	os.Unsetenv("WGCTL_SOCKET")
+}
+
 func Test_KeyToBytes(t *testing.T) {
 	bk := GetKey(t)
 	key := Key(bk)
@@ -305,3 +396,21 @@ func Test_IPMaskTransforms(t *testing.T) {
 
 	assert.Equal(t, "192.168.255.40/16", ipmask.String())
 }
+
+func Test_PeerDescriptions(t *testing.T) {
+	file, err := ioutil.TempFile("", "wgctl")
+	if err != nil {
+		t.Fatalf("could not create temporary file: %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+
+	// The private key is never resolved
+	file.WriteString(strings.Replace(fullConfigYAML, "/tmp/testing.key", "exec:/nonexistent", 1))
+	file.Close()
+
+	descriptions, err := PeerDescriptions(file.Name())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Peer #1", descriptions["7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4="])
+	assert.Equal(t, 3, len(descriptions))
+}
@@ -0,0 +1,126 @@
+package lib
+
+import "fmt"
+
+type diffOp struct {
+	kind byte
+	text string
+}
+
+// UnifiedDiff returns the unified diff turning one text into another, both given as lines, with
+// the given amount of unchanged lines around each change. It returns nil if both are identical.
+func UnifiedDiff(from, to []string, fromName, toName string, context int) []string {
+	ops := diffLines(from, to)
+
+	// Position of each operation in both texts, for hunk headers
+	fromPos, toPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for idx, op := range ops {
+		fromPos[idx+1], toPos[idx+1] = fromPos[idx], toPos[idx]
+		if op.kind != '+' {
+			fromPos[idx+1]++
+		}
+		if op.kind != '-' {
+			toPos[idx+1]++
+		}
+	}
+
+	var out []string
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*context {
+				break
+			}
+		}
+
+		stop := end + context + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		if out == nil {
+			out = []string{fmt.Sprintf("--- %s", fromName), fmt.Sprintf("+++ %s", toName)}
+		}
+
+		out = append(out, fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(fromPos[start], fromPos[stop]-fromPos[start]),
+			hunkRange(toPos[start], toPos[stop]-toPos[start]),
+		))
+		for _, op := range ops[start:stop] {
+			out = append(out, fmt.Sprintf("%c%s", op.kind, op.text))
+		}
+
+		i = stop
+	}
+
+	return out
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// diffLines computes the operations turning one text into another from their longest common
+// subsequence of lines
+func diffLines(from, to []string) []diffOp {
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(from)+len(to))
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			ops = append(ops, diffOp{' ', from[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', from[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', to[j]})
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		ops = append(ops, diffOp{'-', from[i]})
+	}
+	for ; j < len(to); j++ {
+		ops = append(ops, diffOp{'+', to[j]})
+	}
+
+	return ops
+}
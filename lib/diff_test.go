@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_UnifiedDiffIdentical(t *testing.T) {
+	lines := []string{"a", "b", "c"}
+
+	assert.Nil(t, UnifiedDiff(lines, lines, "from", "to", 3))
+}
+
+func Test_UnifiedDiff(t *testing.T) {
+	from := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	to := []string{"a", "c", "d", "e", "f", "g", "h", "i", "x", "j", "k"}
+
+	diff := UnifiedDiff(from, to, "from", "to", 1)
+
+	assert.Equal(t, []string{
+		"--- from",
+		"+++ to",
+		"@@ -1,3 +1,2 @@",
+		" a",
+		"-b",
+		" c",
+		"@@ -9,2 +8,4 @@",
+		" i",
+		"+x",
+		" j",
+		"+k",
+	}, diff)
+}
+
+func Test_UnifiedDiffEmpty(t *testing.T) {
+	diff := UnifiedDiff([]string{}, []string{"a"}, "from", "to", 3)
+
+	assert.Equal(t, []string{"--- from", "+++ to", "@@ -0,0 +1 @@", "+a"}, diff)
+}
@@ -0,0 +1,352 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// PeerFields maps the properties accepted on the command line for a peer to their YAML keys
+var PeerFields = map[string]string{
+	"description": "description",
+	"address":     "address",
+	"pubkey":      "public_key",
+	"psk":         "preshared_key",
+	"endpoint":    "endpoint",
+	"allowedips":  "allowed_ips",
+	"keepalive":   "keepalive_interval",
+	"group":       "group",
+}
+
+// peerFieldOrder is the order in which new peer properties are written to the configuration
+var peerFieldOrder = []string{"description", "address", "pubkey", "psk", "endpoint", "allowedips", "keepalive", "group"}
+
+// ConfigFile is a configuration file loaded as a YAML document, so that it can be edited
+// without losing comments and the ordering of directives.
+type ConfigFile struct {
+	Path string
+	doc  *yaml3.Node
+}
+
+// LoadConfigFile reads the configuration file of an instance as a YAML document
+func LoadConfigFile(instance string) (*ConfigFile, error) {
+	path := GetConfigFile(instance)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read configuration file: %s", err.Error())
+	}
+
+	return ParseConfigFile(path, data)
+}
+
+// ParseConfigFile parses YAML data as an editable configuration document
+func ParseConfigFile(path string, data []byte) (*ConfigFile, error) {
+	doc := new(yaml3.Node)
+	if err := yaml3.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("could not parse configuration file: %s", err.Error())
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml3.MappingNode {
+		return nil, fmt.Errorf("could not parse configuration file: not a YAML mapping")
+	}
+
+	return &ConfigFile{Path: path, doc: doc}, nil
+}
+
+// Bytes returns the YAML representation of the document
+func (f *ConfigFile) Bytes() ([]byte, error) {
+	return encodeDocument(f.doc)
+}
+
+// encodeDocument returns the YAML representation of a document, indented like configurations
+func encodeDocument(doc *yaml3.Node) ([]byte, error) {
+	out := new(bytes.Buffer)
+
+	enc := yaml3.NewEncoder(out)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("could not encode configuration: %s", err.Error())
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("could not encode configuration: %s", err.Error())
+	}
+
+	return out.Bytes(), nil
+}
+
+// Config parses and checks the edited document as a Config, merged with the files it includes
+// and its fragments, and with its values expanded
+func (f *ConfigFile) Config() (*Config, error) {
+	data, err := f.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConfig(f.Path, data)
+}
+
+// Save atomically replaces the configuration file with the edited document, after checking
+// that it is still a valid configuration.
+func (f *ConfigFile) Save() error {
+	if _, err := f.Config(); err != nil {
+		return err
+	}
+
+	data, err := f.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(f.Path, data, 0600)
+}
+
+// AddPeer appends a peer built from a list of properties to the document
+func (f *ConfigFile) AddPeer(props map[string]string) error {
+	peer := &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map"}
+	if err := setPeerFields(peer, props); err != nil {
+		return err
+	}
+
+	if pk, ok := props["pubkey"]; ok {
+		if _, err := f.findPeer(pk); err == nil {
+			return fmt.Errorf("a peer with public key '%s' already exists", pk)
+		}
+	}
+
+	peers := mappingValue(f.doc.Content[0], "peers")
+	if peers == nil {
+		peers = &yaml3.Node{Kind: yaml3.SequenceNode, Tag: "!!seq"}
+		setMappingValue(f.doc.Content[0], "peers", peers)
+	}
+
+	peers.Content = append(peers.Content, peer)
+
+	return nil
+}
+
+// UpdatePeer changes the properties of the peer matching a public key or a description and
+// returns its public key prior to the change
+func (f *ConfigFile) UpdatePeer(match string, props map[string]string) (string, error) {
+	idx, err := f.findPeer(match)
+	if err != nil {
+		return "", err
+	}
+
+	peer := mappingValue(f.doc.Content[0], "peers").Content[idx]
+	pubkey := ""
+	if pk := mappingValue(peer, "public_key"); pk != nil {
+		pubkey = pk.Value
+	}
+
+	return pubkey, setPeerFields(peer, props)
+}
+
+// RemovePeer removes the peer matching a public key or a description from the document and
+// returns its public key
+func (f *ConfigFile) RemovePeer(match string) (string, error) {
+	idx, err := f.findPeer(match)
+	if err != nil {
+		return "", err
+	}
+
+	peers := mappingValue(f.doc.Content[0], "peers")
+	pubkey := ""
+	if pk := mappingValue(peers.Content[idx], "public_key"); pk != nil {
+		pubkey = pk.Value
+	}
+
+	peers.Content = append(peers.Content[:idx], peers.Content[idx+1:]...)
+
+	return pubkey, nil
+}
+
+// IncludedPeerError is returned when editing a peer that is not defined in a configuration
+// file, but in one of the files it includes
+type IncludedPeerError struct {
+	Peer string
+	File string
+}
+
+func (e *IncludedPeerError) Error() string {
+	return fmt.Sprintf("peer '%s' is defined in '%s', which must be edited instead", e.Peer, e.File)
+}
+
+// findPeer returns the index of the peer whose public key or description is the given string
+func (f *ConfigFile) findPeer(match string) (int, error) {
+	peers := mappingValue(f.doc.Content[0], "peers")
+	if peers == nil {
+		return 0, f.peerNotFound(match)
+	}
+
+	found := -1
+	for idx, p := range peers.Content {
+		if pk := mappingValue(p, "public_key"); pk != nil && pk.Value == match {
+			return idx, nil
+		}
+		if desc := mappingValue(p, "description"); desc != nil && desc.Value == match {
+			if found >= 0 {
+				return 0, fmt.Errorf("several peers are described as '%s', use a public key", match)
+			}
+			found = idx
+		}
+	}
+
+	if found < 0 {
+		return 0, f.peerNotFound(match)
+	}
+
+	return found, nil
+}
+
+// peerNotFound returns the error for a peer missing from the document, which tells the file
+// defining it if it comes from an included file or a fragment
+func (f *ConfigFile) peerNotFound(match string) error {
+	data, err := f.Bytes()
+	if err != nil {
+		return fmt.Errorf("could not find peer '%s'", match)
+	}
+
+	doc := new(yaml3.Node)
+	if err := yaml3.Unmarshal(data, doc); err != nil {
+		return fmt.Errorf("could not find peer '%s'", match)
+	}
+
+	files, err := mergeDocument(f.Path, doc)
+	if err != nil || files == nil {
+		return fmt.Errorf("could not find peer '%s'", match)
+	}
+
+	if peers := mappingValue(doc.Content[0], "peers"); peers != nil {
+		for _, p := range peers.Content {
+			pk, desc := mappingValue(p, "public_key"), mappingValue(p, "description")
+
+			if (pk != nil && pk.Value == match) || (desc != nil && desc.Value == match) {
+				return &IncludedPeerError{Peer: match, File: files[p]}
+			}
+		}
+	}
+
+	return fmt.Errorf("could not find peer '%s'", match)
+}
+
+func setPeerFields(peer *yaml3.Node, props map[string]string) error {
+	for k := range props {
+		if _, ok := PeerFields[k]; !ok {
+			return fmt.Errorf("unknown peer property '%s'", k)
+		}
+	}
+
+	for _, k := range peerFieldOrder {
+		v, ok := props[k]
+		if !ok {
+			continue
+		}
+		field := PeerFields[k]
+
+		var value *yaml3.Node
+
+		switch k {
+		case "allowedips":
+			value = &yaml3.Node{Kind: yaml3.SequenceNode, Tag: "!!seq"}
+			for _, ip := range strings.Split(v, ",") {
+				value.Content = append(value.Content, &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: strings.TrimSpace(ip)})
+			}
+		case "keepalive":
+			// Plain numbers are seconds, as for live peers
+			if _, err := strconv.Atoi(v); err == nil {
+				v = fmt.Sprintf("%ss", v)
+			}
+			value = &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: v}
+		default:
+			value = &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: v}
+		}
+
+		if len(v) == 0 {
+			deleteMappingKey(peer, field)
+		} else {
+			setMappingValue(peer, field, value)
+		}
+	}
+
+	return nil
+}
+
+func mappingValue(node *yaml3.Node, key string) *yaml3.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(node *yaml3.Node, key string, value *yaml3.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			// Keep comments attached to the previous value
+			value.LineComment = node.Content[i+1].LineComment
+			node.Content[i+1] = value
+			return
+		}
+	}
+
+	node.Content = append(node.Content, &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func deleteMappingKey(node *yaml3.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it over path, so
+// that readers never see a partially written file. The permissions of an existing file are
+// preserved, otherwise perm is used.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	// A symlink is kept, and the file it points to is replaced instead
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), fmt.Sprintf(".%s.", filepath.Base(path)))
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %s", err.Error())
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write temporary file: %s", err.Error())
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not set permissions on temporary file: %s", err.Error())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write temporary file: %s", err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write temporary file: %s", err.Error())
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not replace '%s': %s", path, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const editableConfigYAML = `# Shared mesh
+description: Lorem ipsum dolor sit amet
+private_key: /tmp/testing.key
+peers:
+  # This is us
+  - description: 'Server'
+    listen_port: 23456
+    public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+  - description: 'Peer #1'
+    public_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+    endpoint: 4.3.2.1:45000 # provider X
+`
+
+func Test_ConfigFileAddPeer(t *testing.T) {
+	createPKey(t)
+	f, err := ParseConfigFile("/tmp/config.yml", []byte(editableConfigYAML))
+	assert.Nil(t, err)
+
+	err = f.AddPeer(map[string]string{
+		"description": "Peer #2",
+		"pubkey":      "uJtUEgdOFdszfiVbMVGdd7/la9k7P9+iUHRzJFtVfWc=",
+		"endpoint":    "[fe80::1]:10000",
+		"allowedips":  "10.0.0.1/32,10.0.1.0/24",
+		"keepalive":   "25",
+	})
+	assert.Nil(t, err)
+
+	c, err := f.Config()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(c.Peers))
+	assert.Equal(t, "Peer #2", c.Peers[1].Description)
+	assert.Equal(t, 2, len(c.Peers[1].AllowedIPS))
+	assert.Equal(t, 25*time.Second, c.Peers[1].KeepaliveInterval)
+	assert.Equal(t, 10000, c.Peers[1].Endpoint.Port)
+
+	out, _ := f.Bytes()
+	assert.Contains(t, string(out), "# Shared mesh")
+	assert.Contains(t, string(out), "# This is us")
+	assert.Contains(t, string(out), "# provider X")
+
+	err = f.AddPeer(map[string]string{"pubkey": "uJtUEgdOFdszfiVbMVGdd7/la9k7P9+iUHRzJFtVfWc="})
+	assert.NotNil(t, err)
+
+	err = f.AddPeer(map[string]string{"unknown": "value"})
+	assert.NotNil(t, err)
+}
+
+func Test_ConfigFileUpdatePeer(t *testing.T) {
+	createPKey(t)
+	f, err := ParseConfigFile("/tmp/config.yml", []byte(editableConfigYAML))
+	assert.Nil(t, err)
+
+	pk, err := f.UpdatePeer("Peer #1", map[string]string{"endpoint": "1.2.3.4:1000", "keepalive": "1m"})
+	assert.Nil(t, err)
+	assert.Equal(t, "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", pk)
+
+	c, err := f.Config()
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3.4", c.Peers[0].Endpoint.IP.String())
+	assert.Equal(t, time.Minute, c.Peers[0].KeepaliveInterval)
+
+	out, _ := f.Bytes()
+	assert.Contains(t, string(out), "endpoint: 1.2.3.4:1000 # provider X")
+
+	_, err = f.UpdatePeer("7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", map[string]string{"endpoint": ""})
+	assert.Nil(t, err)
+
+	c, err = f.Config()
+	assert.Nil(t, err)
+	assert.Nil(t, c.Peers[0].Endpoint)
+
+	_, err = f.UpdatePeer("Nobody", map[string]string{"endpoint": ""})
+	assert.NotNil(t, err)
+}
+
+func Test_ConfigFileRemovePeer(t *testing.T) {
+	createPKey(t)
+	f, err := ParseConfigFile("/tmp/config.yml", []byte(editableConfigYAML))
+	assert.Nil(t, err)
+
+	pk, err := f.RemovePeer("Peer #1")
+	assert.Nil(t, err)
+	assert.Equal(t, "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", pk)
+
+	c, err := f.Config()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(c.Peers))
+
+	_, err = f.RemovePeer("Peer #1")
+	assert.NotNil(t, err)
+
+	// Removing ourselves makes the configuration invalid
+	_, err = f.RemovePeer("Server")
+	assert.Nil(t, err)
+	assert.NotNil(t, f.Save())
+}
+
+func Test_ConfigFileSave(t *testing.T) {
+	createPKey(t)
+	dir, _ := ioutil.TempDir("", "wgctl")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mesh.yml")
+	ioutil.WriteFile(path, []byte(editableConfigYAML), 0640)
+
+	f, err := LoadConfigFile(path)
+	assert.Nil(t, err)
+	assert.Nil(t, f.AddPeer(map[string]string{"pubkey": "uJtUEgdOFdszfiVbMVGdd7/la9k7P9+iUHRzJFtVfWc="}))
+	assert.Nil(t, f.Save())
+
+	c, err := ParseConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(c.Peers))
+
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(files))
+}
+
+func Test_WriteFileAtomicSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wgctl")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "shared.yml")
+	link := filepath.Join(dir, "vpn.yml")
+
+	ioutil.WriteFile(target, []byte("before"), 0600)
+	os.Symlink(target, link)
+
+	assert.Nil(t, WriteFileAtomic(link, []byte("after"), 0600))
+
+	info, err := os.Lstat(link)
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeSymlink, info.Mode()&os.ModeSymlink)
+
+	data, _ := ioutil.ReadFile(target)
+	assert.Equal(t, "after", string(data))
+}
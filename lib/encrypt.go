@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const encryptedKeyType = "WGCTL ENCRYPTED PRIVATE KEY"
+
+// Parameters used to derive the encryption key of new encrypted key files
+const (
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// Passphrase returns the passphrase of an encrypted private key, given the reference it was
+// read from. It can be replaced to prompt the user. By default, it resolves the secret
+// reference (see ResolveSecret) held by the WGCTL_PASSPHRASE environment variable.
+var Passphrase = func(ref string) ([]byte, error) {
+	passphraseRef := os.Getenv("WGCTL_PASSPHRASE")
+	if len(passphraseRef) == 0 {
+		return nil, fmt.Errorf("'%s' is encrypted and WGCTL_PASSPHRASE is not set", ref)
+	}
+
+	return ResolveSecret(passphraseRef)
+}
+
+// ReadPrivateKey reads the base64-encoded private key designated by a file path or a secret
+// reference, decrypting it with Passphrase if it is encrypted. Key files that other users could
+// read are refused (see CheckKeyFile).
+func ReadPrivateKey(ref string) ([wgtypes.KeyLen]byte, error) {
+	var bk [wgtypes.KeyLen]byte
+
+	if path, ok := SecretFilePath(ref); ok {
+		if err := CheckKeyFile(path); errors.Is(err, ErrKeyFileAccessible) || errors.Is(err, ErrKeyFileOwner) {
+			return bk, err
+		}
+	}
+
+	secret, err := ResolveSecret(ref)
+	if err != nil {
+		return bk, fmt.Errorf("could not open private key: %s", err.Error())
+	}
+
+	var key []byte
+	if IsEncryptedKey(secret) {
+		passphrase, err := Passphrase(ref)
+		if err != nil {
+			return bk, fmt.Errorf("could not get passphrase: %s", err.Error())
+		}
+
+		key, err = DecryptKey(secret, passphrase)
+		if err != nil {
+			return bk, err
+		}
+	} else {
+		key, err = base64.StdEncoding.DecodeString(string(secret))
+		if err != nil {
+			return bk, fmt.Errorf("key is of invalid size")
+		}
+	}
+
+	if len(key) != wgtypes.KeyLen {
+		return bk, fmt.Errorf("key is of invalid size")
+	}
+	copy(bk[:], key)
+
+	return bk, nil
+}
+
+// IsEncryptedKey returns whether data holds a passphrase-encrypted private key
+func IsEncryptedKey(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN "+encryptedKeyType+"-----"))
+}
+
+// EncryptKey encrypts a private key with XChaCha20-Poly1305, using a key derived from a
+// passphrase with scrypt, and returns it PEM-encoded.
+func EncryptKey(key, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("could not generate salt: %s", err.Error())
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %s", err.Error())
+	}
+
+	aead, err := keyCipher(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &pem.Block{
+		Type: encryptedKeyType,
+		Headers: map[string]string{
+			"KDF":  "scrypt",
+			"N":    strconv.Itoa(scryptN),
+			"r":    strconv.Itoa(scryptR),
+			"p":    strconv.Itoa(scryptP),
+			"Salt": base64.StdEncoding.EncodeToString(salt),
+		},
+		Bytes: append(nonce, aead.Seal(nil, nonce, key, nil)...),
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// DecryptKey decrypts a private key encrypted by EncryptKey
+func DecryptKey(data, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode(bytes.TrimSpace(data))
+	if block == nil || block.Type != encryptedKeyType {
+		return nil, fmt.Errorf("data is not an encrypted private key")
+	}
+	if block.Headers["KDF"] != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation function '%s'", block.Headers["KDF"])
+	}
+
+	params := make([]int, 3)
+	for idx, name := range []string{"N", "r", "p"} {
+		v, err := strconv.Atoi(block.Headers[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrypt parameter '%s'", name)
+		}
+		params[idx] = v
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %s", err.Error())
+	}
+	if len(block.Bytes) < chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("encrypted key is truncated")
+	}
+
+	aead, err := keyCipher(passphrase, salt, params[0], params[1], params[2])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, ciphertext := block.Bytes[:chacha20poly1305.NonceSizeX], block.Bytes[chacha20poly1305.NonceSizeX:]
+
+	key, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt key, the passphrase is probably wrong")
+	}
+
+	return key, nil
+}
+
+func keyCipher(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	dk, err := scrypt.Key(passphrase, salt, n, r, p, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("could not derive key from passphrase: %s", err.Error())
+	}
+
+	return chacha20poly1305.NewX(dk)
+}
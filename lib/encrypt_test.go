@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EncryptKey(t *testing.T) {
+	key, _ := base64.StdEncoding.DecodeString("7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=")
+
+	data, err := EncryptKey(key, []byte("passphrase"))
+	assert.Nil(t, err)
+	assert.True(t, IsEncryptedKey(data))
+	assert.False(t, IsEncryptedKey([]byte("7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=")))
+
+	decrypted, err := DecryptKey(data, []byte("passphrase"))
+	assert.Nil(t, err)
+	assert.Equal(t, key, decrypted)
+
+	_, err = DecryptKey(data, []byte("wrong"))
+	assert.NotNil(t, err)
+}
+
+func Test_ParseConfigWithEncryptedKey(t *testing.T) {
+	key, _ := base64.StdEncoding.DecodeString("7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=")
+	data, _ := EncryptKey(key, []byte("passphrase"))
+
+	ioutil.WriteFile("/tmp/testing-encrypted.key", data, 0600)
+	defer os.Remove("/tmp/testing-encrypted.key")
+
+	yml := strings.Replace(minimalConfigYAML, "/tmp/testing.key", "/tmp/testing-encrypted.key", 1)
+
+	_, err := ParseConfigReader(strings.NewReader(yml))
+	assert.NotNil(t, err)
+
+	os.Setenv("WGCTL_TEST_PASSPHRASE", "passphrase")
+	os.Setenv("WGCTL_PASSPHRASE", "env:WGCTL_TEST_PASSPHRASE")
+	defer os.Unsetenv("WGCTL_TEST_PASSPHRASE")
+	defer os.Unsetenv("WGCTL_PASSPHRASE")
+
+	config, err := ParseConfigReader(strings.NewReader(yml))
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/testing-encrypted.key", config.PrivateKey.Path)
+	assert.Equal(t, "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", config.PrivateKey.String())
+}
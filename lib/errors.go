@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// ErrConfigInvalid is wrapped by errors caused by a configuration that cannot be used
+var ErrConfigInvalid = errors.New("invalid configuration")
+
+// invalidConfigError marks an error as caused by an invalid configuration, while keeping its
+// cause available to errors.As.
+type invalidConfigError struct {
+	err error
+}
+
+func (e invalidConfigError) Error() string {
+	return e.err.Error()
+}
+
+func (e invalidConfigError) Unwrap() error {
+	return e.err
+}
+
+func (e invalidConfigError) Is(target error) bool {
+	return target == ErrConfigInvalid
+}
+
+// FieldError is an invalid value found in a configuration file, or in one of the files it
+// includes. File is empty for configurations that were not read from a file.
+type FieldError struct {
+	File   string
+	Path   string
+	Line   int
+	Column int
+	Err    error
+}
+
+// Error returns the representation of a field error, in the form of
+// <path> (<file>, line <l>, column <c>): <cause>
+func (e *FieldError) Error() string {
+	if len(e.File) > 0 {
+		return fmt.Sprintf("%s (%s, line %d, column %d): %s", e.Path, e.File, e.Line, e.Column, e.Err.Error())
+	}
+	return fmt.Sprintf("%s (line %d, column %d): %s", e.Path, e.Line, e.Column, e.Err.Error())
+}
+
+// Unwrap returns the cause of a field error
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors holds all the invalid values found in a configuration file
+type ConfigErrors []*FieldError
+
+// Error returns all field errors, one per line
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for idx, err := range e {
+		lines[idx] = err.Error()
+	}
+
+	return fmt.Sprintf("%d invalid value(s):\n  %s", len(e), strings.Join(lines, "\n  "))
+}
+
+// Is returns whether the cause of any of the field errors matches target
+func (e ConfigErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	typeErrorLine   = regexp.MustCompile(`^line \d+: `)
+
+	privateKeyType   = reflect.TypeOf(PrivateKey{})
+	presharedKeyType = reflect.TypeOf(PresharedKey{})
+)
+
+// secretError is an error raised while resolving the secret a key references
+type secretError struct {
+	ref string
+	err error
+}
+
+func (e secretError) Error() string {
+	return e.err.Error()
+}
+
+func (e secretError) Unwrap() error {
+	return e.err
+}
+
+// errorCollector holds the state of collectErrors while it walks a document
+type errorCollector struct {
+	files  nodeFiles
+	failed *secretError
+	errs   ConfigErrors
+}
+
+// collectErrors decodes every value of a YAML document read from path into the type it is
+// destined to and returns all the values that could not be decoded, instead of stopping at the
+// first one, at the position they have in the file they were read from (see mergeDocument).
+// Secret references are not resolved again: the one that failed in cause, the error returned by
+// decoding the whole document, is reported where it is referenced.
+func collectErrors(doc *yaml3.Node, path string, files nodeFiles, t reflect.Type, cause error) ConfigErrors {
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	c := &errorCollector{files: files, errs: make(ConfigErrors, 0)}
+	if serr := (secretError{}); errors.As(cause, &serr) {
+		c.failed = &serr
+	}
+
+	c.collect(doc.Content[0], path, "", t)
+
+	return c.errs
+}
+
+func (c *errorCollector) collect(node *yaml3.Node, file, path string, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if f, ok := c.files[node]; ok {
+		file = f
+	}
+
+	if node.Kind == yaml3.ScalarNode && referencesSecret(t, node.Value) {
+		if c.failed != nil && c.failed.ref == node.Value {
+			c.errs = append(c.errs, &FieldError{File: file, Path: path, Line: node.Line, Column: node.Column, Err: c.failed.err})
+		}
+		return
+	}
+
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		c.decode(node, file, path, t)
+		return
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+
+			field, ok := fieldByTag(t, key)
+			if !ok {
+				continue
+			}
+
+			fieldPath := key
+			if len(path) > 0 {
+				fieldPath = fmt.Sprintf("%s.%s", path, key)
+			}
+
+			c.collect(value, file, fieldPath, field.Type)
+		}
+
+	case t.Kind() == reflect.Map && node.Kind == yaml3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			c.collect(node.Content[i+1], file, fmt.Sprintf("%s.%s", path, node.Content[i].Value), t.Elem())
+		}
+
+	case t.Kind() == reflect.Slice && node.Kind == yaml3.SequenceNode:
+		for idx, item := range node.Content {
+			c.collect(item, file, fmt.Sprintf("%s[%d]", path, idx), t.Elem())
+		}
+
+	default:
+		c.decode(node, file, path, t)
+	}
+}
+
+// referencesSecret returns whether decoding a value of the given type would resolve a secret,
+// which can run a command or prompt for a passphrase
+func referencesSecret(t reflect.Type, value string) bool {
+	switch t {
+	case privateKeyType:
+		return !isInlineKey(value)
+	case presharedKeyType:
+		return IsSecretRef(value)
+	}
+	return false
+}
+
+// decode decodes a single value the same way the whole configuration is decoded
+func (c *errorCollector) decode(node *yaml3.Node, file, path string, t reflect.Type) {
+	data, err := yaml3.Marshal(node)
+	if err != nil {
+		return
+	}
+
+	err = yaml.Unmarshal(data, reflect.New(t).Interface())
+	if err == nil {
+		return
+	}
+
+	if typeErr, ok := err.(*yaml.TypeError); ok && len(typeErr.Errors) > 0 {
+		err = fmt.Errorf("%s", typeErrorLine.ReplaceAllString(typeErr.Errors[0], ""))
+	}
+
+	c.errs = append(c.errs, &FieldError{File: file, Path: path, Line: node.Line, Column: node.Column, Err: err})
+}
+
+// fieldByTag finds the field of a struct decoded from the given YAML key
+func fieldByTag(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+		if len(name) == 0 {
+			name = strings.ToLower(field.Name)
+		}
+		if name == key {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
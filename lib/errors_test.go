@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const invalidConfigYAML = `description: Lorem ipsum dolor sit amet
+private_key: /tmp/testing.key
+peers:
+  - description: 'Server'
+    address: 10.0.0.1/24
+    listen_port: abc
+    public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+  - public_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+    endpoint: nowhere
+    allowed_ips:
+      - 10.0.0.2/32
+      - 10.0.0.300/32
+  - public_key: invalid
+`
+
+func Test_ParseConfigErrors(t *testing.T) {
+	createPKey(t)
+	_, err := ParseConfigReader(bytes.NewReader([]byte(invalidConfigYAML)))
+	assert.NotNil(t, err)
+
+	var errs ConfigErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 4, len(errs))
+
+	assert.Equal(t, "peers[0].listen_port", errs[0].Path)
+	assert.Equal(t, 6, errs[0].Line)
+	assert.Equal(t, 18, errs[0].Column)
+
+	assert.Equal(t, "peers[1].endpoint", errs[1].Path)
+	assert.Equal(t, 9, errs[1].Line)
+	assert.Equal(t, "could not parse UDP address: nowhere", errs[1].Err.Error())
+
+	assert.Equal(t, "peers[1].allowed_ips[1]", errs[2].Path)
+	assert.Equal(t, 12, errs[2].Line)
+	assert.Equal(t, 9, errs[2].Column)
+
+	assert.Equal(t, "peers[2].public_key", errs[3].Path)
+	assert.Equal(t, 13, errs[3].Line)
+
+	assert.Contains(t, err.Error(), "peers[1].allowed_ips[1] (line 12, column 9): could not parse IP address: 10.0.0.300/32")
+}
+
+func Test_ParseConfigSyntaxError(t *testing.T) {
+	_, err := ParseConfigReader(bytes.NewReader([]byte("peers: [\n")))
+	assert.NotNil(t, err)
+
+	var errs ConfigErrors
+	assert.False(t, errors.As(err, &errs))
+}
+
+func Test_ErrConfigInvalid(t *testing.T) {
+	createPKey(t)
+	_, err := ParseConfigReader(bytes.NewReader([]byte(invalidConfigYAML)))
+	assert.True(t, errors.Is(err, ErrConfigInvalid))
+
+	_, err = ParseConfigReader(bytes.NewReader([]byte("private_key: /tmp/testing.key\npeers: []\n")))
+	assert.True(t, errors.Is(err, ErrConfigInvalid))
+
+	_, err = ParseConfig("/tmp/wgctl-missing.yml")
+	assert.False(t, errors.Is(err, ErrConfigInvalid))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func Test_ParseConfigErrorsResolveSecretsOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wgctl")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	counter := filepath.Join(dir, "counter")
+	script := filepath.Join(dir, "key.sh")
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho >> "+counter+"\necho 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=\n"), 0700)
+
+	config := strings.Replace(invalidConfigYAML, "/tmp/testing.key", "exec:"+script, 1)
+	_, err = ParseConfigReader(bytes.NewReader([]byte(config)))
+
+	var errs ConfigErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 4, len(errs))
+
+	calls, _ := ioutil.ReadFile(counter)
+	assert.Equal(t, "\n", string(calls))
+}
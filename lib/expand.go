@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+var (
+	variablePattern = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+	envKeyPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// hookKeys are the directives holding commands, which are run as written: their arguments
+	// commonly use templates and variables of their own (e.g. sh -c 'echo ${VAR}')
+	hookKeys = map[string]bool{"post_up": true, "pre_down": true}
+)
+
+// TemplateContext holds the values available to the templates used in configuration values
+// (e.g. {{.Hostname}})
+type TemplateContext struct {
+	Hostname  string
+	Interface string
+	ConfigDir string
+}
+
+// NewTemplateContext returns the template context of the configuration file at path, or of a
+// configuration read from elsewhere if path is empty
+func NewTemplateContext(path string) TemplateContext {
+	ctx := TemplateContext{ConfigDir: GetConfigPath()}
+	ctx.Hostname, _ = os.Hostname()
+
+	if len(path) > 0 {
+		ctx.Interface = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		ctx.ConfigDir = filepath.Dir(path)
+	}
+
+	return ctx
+}
+
+// ExpandValues renders the Go templates and replaces the ${VAR} and ${VAR:-default} references
+// to environment variables found in the string values of YAML data, except in hooks. Variables
+// without a default value must be set, and $${VAR} is left as ${VAR}. The data is returned as
+// is if there is nothing to expand.
+func ExpandValues(data []byte, ctx TemplateContext) ([]byte, error) {
+	if !bytes.Contains(data, []byte("${")) && !bytes.Contains(data, []byte("{{")) {
+		return data, nil
+	}
+
+	doc := new(yaml3.Node)
+	if err := yaml3.Unmarshal(data, doc); err != nil {
+		// Let the configuration parser report the error
+		return data, nil
+	}
+
+	expanded, err := expandNode(doc, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !expanded {
+		return data, nil
+	}
+
+	return encodeDocument(doc)
+}
+
+// expandNode expands the string values of a document in place (see ExpandValues), and returns
+// whether any was changed. The nodes keep their position.
+func expandNode(node *yaml3.Node, ctx TemplateContext) (bool, error) {
+	if node.Kind != yaml3.ScalarNode {
+		expanded := false
+		for idx, child := range node.Content {
+			// Keys and hooks are left alone
+			if node.Kind == yaml3.MappingNode && (idx%2 == 0 || hookKeys[node.Content[idx-1].Value]) {
+				continue
+			}
+			changed, err := expandNode(child, ctx)
+			if err != nil {
+				return false, err
+			}
+			expanded = expanded || changed
+		}
+		return expanded, nil
+	}
+
+	value, err := expandString(node.Value, ctx)
+	if err != nil {
+		return false, fmt.Errorf("could not expand value at line %d: %s", node.Line, err.Error())
+	}
+	if value == node.Value {
+		return false, nil
+	}
+
+	node.Value = value
+	// Unquoted values are typed after expansion, so that numbers can be templated
+	if node.Style == 0 {
+		node.Tag = ""
+	}
+
+	return true, nil
+}
+
+func expandString(value string, ctx TemplateContext) (string, error) {
+	if strings.Contains(value, "{{") {
+		tmpl, err := template.New("value").Option("missingkey=error").Parse(value)
+		if err != nil {
+			return "", err
+		}
+
+		out := new(strings.Builder)
+		if err := tmpl.Execute(out, ctx); err != nil {
+			return "", err
+		}
+		value = out.String()
+	}
+
+	var missing error
+	value = variablePattern.ReplaceAllStringFunc(value, func(ref string) string {
+		m := variablePattern.FindStringSubmatch(ref)
+
+		// $${VAR} is kept as ${VAR}
+		if len(m[1]) > 0 {
+			return ref[1:]
+		}
+		if v := os.Getenv(m[2]); len(v) > 0 {
+			return v
+		}
+		if len(m[3]) > 0 {
+			return m[4]
+		}
+		if _, ok := os.LookupEnv(m[2]); !ok && missing == nil {
+			missing = fmt.Errorf("environment variable '%s' is not set", m[2])
+		}
+		return ""
+	})
+
+	return value, missing
+}
+
+// LoadEnvFile sets the environment variables defined in a file, as KEY=VALUE lines, unless they
+// are already set. Empty lines and lines starting with # are ignored, and values can be quoted.
+func LoadEnvFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not read environment file: %s", err.Error())
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(strings.TrimPrefix(text, "export "), "=", 2)
+		if len(kv) != 2 || !envKeyPattern.MatchString(strings.TrimSpace(kv[0])) {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, line)
+		}
+
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		if _, ok := os.LookupEnv(key); !ok {
+			os.Setenv(key, value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read environment file: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const expandableConfigYAML = `
+description: "{{.Interface}} in {{.ConfigDir}}, ${WGCTL_TEST_SITE:-main site}"
+private_key: ${WGCTL_TEST_KEY}
+peers:
+  - listen_port: ${WGCTL_TEST_PORT}
+    public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+    post_up:
+      - - /bin/sh
+        - -c
+        - echo ${WGCTL_TEST_PORT} {{.State.Pid}} $${WGCTL_TEST_PORT}
+`
+
+func Test_ParseConfigWithVariables(t *testing.T) {
+	createPKey(t)
+
+	os.Setenv("WGCTL_TEST_KEY", "/tmp/testing.key")
+	os.Setenv("WGCTL_TEST_PORT", "23456")
+	defer os.Unsetenv("WGCTL_TEST_KEY")
+	defer os.Unsetenv("WGCTL_TEST_PORT")
+
+	dir, err := ioutil.TempDir("", "wgctl")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vpn.yml")
+	ioutil.WriteFile(path, []byte(expandableConfigYAML), 0600)
+
+	c, err := ParseConfig(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "vpn in "+dir+", main site", c.Description)
+	assert.Equal(t, "/tmp/testing.key", c.PrivateKey.Path)
+	assert.Equal(t, 23456, c.Self.ListenPort)
+	assert.Equal(t, "echo ${WGCTL_TEST_PORT} {{.State.Pid}} $${WGCTL_TEST_PORT}", c.Self.PostUp[0][2])
+}
+
+func Test_ParseConfigWithMissingVariable(t *testing.T) {
+	createPKey(t)
+
+	os.Setenv("WGCTL_TEST_KEY", "/tmp/testing.key")
+	defer os.Unsetenv("WGCTL_TEST_KEY")
+
+	_, err := ParseConfigReader(bytes.NewReader([]byte(expandableConfigYAML)))
+
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrConfigInvalid))
+	assert.Contains(t, err.Error(), "'WGCTL_TEST_PORT' is not set")
+}
+
+func Test_ParseConfigWithVariablesErrorLines(t *testing.T) {
+	createPKey(t)
+
+	yml := `
+description: ${WGCTL_TEST_SITE:-main site}
+private_key: /tmp/testing.key
+
+peers:
+  - public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+
+    allowed_ips: [10.1.0.0/24, invalid]
+`
+
+	_, err := ParseConfigReader(bytes.NewReader([]byte(yml)))
+
+	var errs ConfigErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, 8, errs[0].Line)
+}
+
+func Test_ExpandValuesKeepsData(t *testing.T) {
+	data, err := ExpandValues([]byte(minimalConfigYAML), NewTemplateContext(""))
+
+	assert.Nil(t, err)
+	assert.Equal(t, minimalConfigYAML, string(data))
+}
+
+func Test_LoadEnvFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "wgctl")
+	if err != nil {
+		t.Fatalf("could not create temporary file: %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+
+	os.Setenv("WGCTL_TEST_SET", "kept")
+	defer os.Unsetenv("WGCTL_TEST_SET")
+	defer os.Unsetenv("WGCTL_TEST_PLAIN")
+	defer os.Unsetenv("WGCTL_TEST_QUOTED")
+
+	file.WriteString("# comment\n\nWGCTL_TEST_PLAIN=value\nexport WGCTL_TEST_QUOTED=\"quoted value\"\nWGCTL_TEST_SET=replaced\n")
+	file.Close()
+
+	assert.Nil(t, LoadEnvFile(file.Name()))
+	assert.Equal(t, "value", os.Getenv("WGCTL_TEST_PLAIN"))
+	assert.Equal(t, "quoted value", os.Getenv("WGCTL_TEST_QUOTED"))
+	assert.Equal(t, "kept", os.Getenv("WGCTL_TEST_SET"))
+
+	ioutil.WriteFile(file.Name(), []byte("not a variable\n"), 0600)
+
+	assert.NotNil(t, LoadEnvFile(file.Name()))
+}
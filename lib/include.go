@@ -0,0 +1,223 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// FragmentsDir returns the directory holding the fragments merged into a configuration file
+// (e.g. /etc/wireguard/vpn1.d for /etc/wireguard/vpn1.yml)
+func FragmentsDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".d"
+}
+
+// ExpandIncludes merges into the YAML configuration data read from path the files listed in its
+// 'include' directive, in order, then the fragments found in its FragmentsDir, in lexical order.
+// A file takes precedence over the files it includes, and fragments over the configuration: the
+// directives of a file replace those already set, and its peers are appended, or replace the
+// properties of the peer with the same public key. Relative includes are resolved from the
+// directory of the including file, and can be globs. The data is returned as is if there is
+// nothing to merge.
+func ExpandIncludes(path string, data []byte) ([]byte, error) {
+	doc := new(yaml3.Node)
+	if err := yaml3.Unmarshal(data, doc); err != nil {
+		// Let the configuration parser report the error
+		return data, nil
+	}
+
+	files, err := mergeDocument(path, doc)
+	if err != nil {
+		return nil, err
+	}
+	if files == nil {
+		return data, nil
+	}
+
+	return encodeDocument(doc)
+}
+
+// nodeFiles maps the nodes of a merged document to the file they were read from. Only the
+// top-level values, peers and peer properties are recorded: their children come from the same
+// file.
+type nodeFiles map[*yaml3.Node]string
+
+// merger holds the state of mergeDocument
+type merger struct {
+	root  *yaml3.Node
+	seen  map[string]bool
+	files nodeFiles
+}
+
+// mergeDocument merges the files included by the document read from path, and its fragments,
+// into the document itself (see ExpandIncludes). The nodes of the document keep their position,
+// and the file they come from is returned, or nil if nothing was merged.
+func mergeDocument(path string, doc *yaml3.Node) (nodeFiles, error) {
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml3.MappingNode {
+		return nil, nil
+	}
+
+	fragments, err := filepath.Glob(filepath.Join(FragmentsDir(path), "*.yml"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list configuration fragments: %s", err.Error())
+	}
+	sort.Strings(fragments)
+
+	if mappingValue(doc.Content[0], "include") == nil && len(fragments) == 0 {
+		return nil, nil
+	}
+
+	m := &merger{
+		root:  &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map"},
+		seen:  map[string]bool{filepath.Clean(path): true},
+		files: make(nodeFiles),
+	}
+
+	if err := m.mergeMapping(doc.Content[0], path); err != nil {
+		return nil, err
+	}
+
+	for _, fragment := range fragments {
+		if err := m.mergeFile(fragment); err != nil {
+			return nil, err
+		}
+	}
+
+	doc.Content[0] = m.root
+
+	return m.files, nil
+}
+
+// mergeFile merges a configuration file, along with the files it includes, into the document
+func (m *merger) mergeFile(path string) error {
+	if m.seen[filepath.Clean(path)] {
+		return fmt.Errorf("'%s' is included several times", path)
+	}
+	m.seen[filepath.Clean(path)] = true
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read included file: %s", err.Error())
+	}
+
+	doc := new(yaml3.Node)
+	if err := yaml3.Unmarshal(data, doc); err != nil {
+		return fmt.Errorf("could not parse included file '%s': %s", path, err.Error())
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	if doc.Content[0].Kind != yaml3.MappingNode {
+		return fmt.Errorf("could not parse included file '%s': not a YAML mapping", path)
+	}
+
+	return m.mergeMapping(doc.Content[0], path)
+}
+
+// mergeMapping merges the files included by the mapping read from path, then the mapping
+// itself, into the document, so that the mapping takes precedence over its includes
+func (m *merger) mergeMapping(src *yaml3.Node, path string) error {
+	if err := m.mergeIncludes(src, path); err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i].Value, src.Content[i+1]
+
+		switch {
+		case key == "include":
+			continue
+		case key == "peers" && value.Kind == yaml3.SequenceNode:
+			m.mergePeers(value, path)
+		default:
+			setMappingValue(m.root, key, value)
+			m.files[value] = path
+		}
+	}
+
+	return nil
+}
+
+// mergeIncludes merges the files listed in the 'include' directive of the mapping read from
+// path into the document
+func (m *merger) mergeIncludes(src *yaml3.Node, path string) error {
+	include := mappingValue(src, "include")
+	if include == nil {
+		return nil
+	}
+
+	patterns := []string{}
+	switch include.Kind {
+	case yaml3.ScalarNode:
+		patterns = append(patterns, include.Value)
+	case yaml3.SequenceNode:
+		for _, node := range include.Content {
+			if node.Kind != yaml3.ScalarNode {
+				return fmt.Errorf("%s:%d: 'include' must be a path or a list of paths", path, node.Line)
+			}
+			patterns = append(patterns, node.Value)
+		}
+	default:
+		return fmt.Errorf("%s:%d: 'include' must be a path or a list of paths", path, include.Line)
+	}
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		files := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: invalid include pattern '%s': %s", path, pattern, err.Error())
+			}
+			sort.Strings(matches)
+			files = matches
+		}
+
+		for _, file := range files {
+			if err := m.mergeFile(file); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// mergePeers appends the peers read from path to the document, or merges their properties into
+// the peer with the same public key
+func (m *merger) mergePeers(peers *yaml3.Node, path string) {
+	dst := mappingValue(m.root, "peers")
+	if dst == nil || dst.Kind != yaml3.SequenceNode {
+		dst = &yaml3.Node{Kind: yaml3.SequenceNode, Tag: "!!seq"}
+		setMappingValue(m.root, "peers", dst)
+	}
+
+	for _, peer := range peers.Content {
+		var existing *yaml3.Node
+		if pk := mappingValue(peer, "public_key"); pk != nil {
+			for _, p := range dst.Content {
+				if dpk := mappingValue(p, "public_key"); dpk != nil && dpk.Value == pk.Value {
+					existing = p
+				}
+			}
+		}
+
+		if existing == nil || peer.Kind != yaml3.MappingNode {
+			dst.Content = append(dst.Content, peer)
+			m.files[peer] = path
+			continue
+		}
+
+		for i := 0; i+1 < len(peer.Content); i += 2 {
+			setMappingValue(existing, peer.Content[i].Value, peer.Content[i+1])
+			m.files[peer.Content[i+1]] = path
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const includingConfigYAML = `
+description: Main
+private_key: /tmp/testing.key
+include: shared/*.yml
+peers:
+  - public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+    listen_port: 23456
+`
+
+const sharedPeersYAML = `
+description: Shared
+peers:
+  - description: Gateway
+    public_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+    endpoint: 1.2.3.4:23456
+    allowed_ips:
+      - 10.0.0.0/24
+`
+
+const hostFragmentYAML = `
+peers:
+  - public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+    routes: false
+    post_up:
+      - [/bin/true]
+`
+
+func writeIncludes(t *testing.T) string {
+	createPKey(t)
+
+	dir, err := ioutil.TempDir("", "wgctl")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err.Error())
+	}
+
+	os.MkdirAll(filepath.Join(dir, "shared"), 0755)
+	os.MkdirAll(filepath.Join(dir, "vpn.d"), 0755)
+
+	ioutil.WriteFile(filepath.Join(dir, "vpn.yml"), []byte(includingConfigYAML), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "shared", "peers.yml"), []byte(sharedPeersYAML), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "vpn.d", "host.yml"), []byte(hostFragmentYAML), 0600)
+
+	return dir
+}
+
+func Test_ParseConfigWithIncludes(t *testing.T) {
+	dir := writeIncludes(t)
+	defer os.RemoveAll(dir)
+
+	c, err := ParseConfig(filepath.Join(dir, "vpn.yml"))
+	assert.Nil(t, err)
+
+	// The configuration takes precedence over the files it includes
+	assert.Equal(t, "Main", c.Description)
+	assert.Equal(t, 23456, c.Self.ListenPort)
+	assert.False(t, *c.Self.SetUpRoutes)
+	assert.Equal(t, [][]string{{"/bin/true"}}, c.Self.PostUp)
+	assert.Len(t, c.Peers, 1)
+	assert.Equal(t, "Gateway", c.Peers[0].Description)
+}
+
+func Test_ParseConfigFragmentsPrecedence(t *testing.T) {
+	dir := writeIncludes(t)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "vpn.d", "description.yml"), []byte("description: Host\n"), 0600)
+
+	c, err := ParseConfig(filepath.Join(dir, "vpn.yml"))
+	assert.Nil(t, err)
+
+	// Fragments take precedence over the configuration
+	assert.Equal(t, "Host", c.Description)
+}
+
+func Test_ExpandIncludesWithoutIncludes(t *testing.T) {
+	data, err := ExpandIncludes("/tmp/none.yml", []byte(minimalConfigYAML))
+	assert.Nil(t, err)
+	assert.Equal(t, minimalConfigYAML, string(data))
+}
+
+func Test_ExpandIncludesErrors(t *testing.T) {
+	dir := writeIncludes(t)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "shared", "loop.yml"), []byte("include: ../vpn.yml\n"), 0600)
+
+	_, err := ParseConfig(filepath.Join(dir, "vpn.yml"))
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrConfigInvalid))
+
+	_, err = ExpandIncludes(filepath.Join(dir, "other.yml"), []byte("include: missing.yml\n"))
+	assert.NotNil(t, err)
+}
+
+func Test_ParseConfigWithIncludesErrorLines(t *testing.T) {
+	dir := writeIncludes(t)
+	defer os.RemoveAll(dir)
+
+	yml := includingConfigYAML + "\n    allowed_ips: [10.1.0.0/24, invalid]\n"
+	ioutil.WriteFile(filepath.Join(dir, "vpn.yml"), []byte(yml), 0600)
+
+	_, err := ParseConfig(filepath.Join(dir, "vpn.yml"))
+
+	var errs ConfigErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, filepath.Join(dir, "vpn.yml"), errs[0].File)
+	assert.Equal(t, "peers[1].allowed_ips[1]", errs[0].Path)
+	assert.Equal(t, 9, errs[0].Line)
+}
+
+func Test_ParseConfigFragmentErrorFiles(t *testing.T) {
+	dir := writeIncludes(t)
+	defer os.RemoveAll(dir)
+
+	fragment := filepath.Join(dir, "vpn.d", "gateway.yml")
+	yml := "peers:\n  - public_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=\n    keepalive_interval: often\n"
+	ioutil.WriteFile(fragment, []byte(yml), 0600)
+
+	_, err := ParseConfig(filepath.Join(dir, "vpn.yml"))
+
+	var errs ConfigErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, fragment, errs[0].File)
+	assert.Equal(t, "peers[0].keepalive_interval", errs[0].Path)
+	assert.Equal(t, 3, errs[0].Line)
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "vpn.yml"))
+	findings, _ := ValidateData(filepath.Join(dir, "vpn.yml"), data)
+	assert.Len(t, findings, 1)
+	assert.Equal(t, fragment, findings[0].File)
+	assert.Equal(t, 3, findings[0].Line)
+}
+
+func Test_EditIncludedPeer(t *testing.T) {
+	dir := writeIncludes(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vpn.yml")
+	data, _ := ioutil.ReadFile(path)
+
+	f, err := ParseConfigFile(path, data)
+	assert.Nil(t, err)
+	config, err := f.Config()
+	assert.Nil(t, err)
+
+	skipped, err := f.RotatePresharedKeys(config)
+	assert.Nil(t, err)
+	assert.Len(t, skipped, 1)
+	assert.Equal(t, "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", skipped[0].Peer)
+	assert.Equal(t, filepath.Join(dir, "shared", "peers.yml"), skipped[0].File)
+	assert.Nil(t, config.Peers[0].PresharedKey)
+
+	var ierr *IncludedPeerError
+
+	_, err = f.UpdatePeer("Gateway", map[string]string{"keepalive": "10"})
+	assert.True(t, errors.As(err, &ierr))
+	assert.Equal(t, filepath.Join(dir, "shared", "peers.yml"), ierr.File)
+
+	_, err = f.RemovePeer("Gateway")
+	assert.True(t, errors.As(err, &ierr))
+
+	_, err = f.RemovePeer("Unknown")
+	assert.EqualError(t, err, "could not find peer 'Unknown'")
+}
@@ -2,8 +2,18 @@ package lib
 
 import (
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"golang.org/x/crypto/curve25519"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -25,9 +35,17 @@ func GeneratePrivateKey() (*PrivateKey, error) {
 		return nil, fmt.Errorf("could not generate key: %s", err.Error())
 	}
 
+	clamp(priv)
+
 	return &PrivateKey{Data: *priv}, nil
 }
 
+// clamp turns random bytes into a Curve25519 private key, as wg(8) does
+func clamp(priv *[wgtypes.KeyLen]byte) {
+	priv[0] &= 248
+	priv[31] = (priv[31] & 127) | 64
+}
+
 // ComputePublicKey computes the matching Curve25519 public key from a private key
 func ComputePublicKey(b []byte) Key {
 	priv := new([wgtypes.KeyLen]byte)
@@ -49,3 +67,144 @@ func GeneratePSK() (PresharedKey, error) {
 
 	return PresharedKey(priv[:]), nil
 }
+
+// ParsePresharedKey decodes a preshared key given in base64, as used by wg(8), or in hex
+func ParsePresharedKey(s string) (PresharedKey, error) {
+	s = strings.TrimSpace(s)
+
+	info, err := InspectKey(s)
+	if err != nil {
+		return nil, fmt.Errorf("preshared key is of invalid size")
+	}
+
+	return PresharedKey(info.Data[:]), nil
+}
+
+// Encodings in which keys can be written
+const (
+	KeyEncodingBase64 = "base64"
+	KeyEncodingHex    = "hex"
+)
+
+// Kinds of keys told apart by InspectKey
+const (
+	KeyKindMaybePrivate = "could be a private key"
+	KeyKindPublicOrPSK  = "public key or preshared key"
+	KeyKindPSK          = "preshared key"
+)
+
+// KeyInfo describes a key given as a string
+type KeyInfo struct {
+	Encoding string
+	Kind     string
+	Data     [wgtypes.KeyLen]byte
+	Clamped  bool
+}
+
+// InspectKey decodes a key and tells what it can be used as. Any 32 bytes can be a public key
+// or a preshared key, but only clamped ones are valid Curve25519 private keys, so a clamped key
+// could be a private key, but is not necessarily one. Keys written in
+// hex can only be preshared keys, since wgctl uses that encoding for nothing else.
+func InspectKey(s string) (*KeyInfo, error) {
+	s = strings.TrimSpace(s)
+	info := &KeyInfo{Encoding: KeyEncodingBase64}
+
+	key, err := base64.StdEncoding.DecodeString(s)
+	if len(s) == hex.EncodedLen(wgtypes.KeyLen) {
+		info.Encoding = KeyEncodingHex
+		key, err = hex.DecodeString(s)
+	}
+	if err != nil || len(key) != wgtypes.KeyLen {
+		return nil, fmt.Errorf("not a base64 or hex-encoded key of %d bytes", wgtypes.KeyLen)
+	}
+
+	copy(info.Data[:], key)
+	info.Clamped = key[0]&7 == 0 && key[31]&128 == 0 && key[31]&64 != 0
+
+	switch {
+	case info.Encoding == KeyEncodingHex:
+		info.Kind = KeyKindPSK
+	case info.Clamped:
+		info.Kind = KeyKindMaybePrivate
+	default:
+		info.Kind = KeyKindPublicOrPSK
+	}
+
+	return info, nil
+}
+
+// Errors returned when a private key file could be read by other users
+var (
+	ErrKeyFileAccessible = errors.New("private key file is accessible by other users")
+	ErrKeyFileOwner      = errors.New("private key file is owned by another user")
+)
+
+// NetworkdGroup is the group systemd-networkd runs as, which may read the private keys of the
+// tunnels it manages
+const NetworkdGroup = "systemd-network"
+
+// CheckKeyFile verifies that a private key file is only accessible by its owner, and that it
+// is owned by root or by the current user. The file can also be readable by NetworkdGroup.
+func CheckKeyFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	perm := info.Mode().Perm()
+	if perm&0037 != 0 || (perm&0040 != 0 && !ownedByGroup(info, NetworkdGroup)) {
+		return fmt.Errorf("%w: %s has mode %#o, run 'chmod 600 %s'", ErrKeyFileAccessible, path, perm, path)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%w: %s belongs to uid %d", ErrKeyFileOwner, path, st.Uid)
+	}
+
+	return nil
+}
+
+// ownedByGroup returns whether a file belongs to the given group
+func ownedByGroup(info os.FileInfo, name string) bool {
+	group, err := user.LookupGroup(name)
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+
+	return ok && strconv.FormatUint(uint64(st.Gid), 10) == group.Gid
+}
+
+// WriteKeyFile atomically creates a file only accessible by its owner, regardless of the umask,
+// holding a key. An existing file is never replaced.
+func WriteKeyFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), fmt.Sprintf(".%s.", filepath.Base(path)))
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %s", err.Error())
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not set permissions on temporary file: %s", err.Error())
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write temporary file: %s", err.Error())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write temporary file: %s", err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write temporary file: %s", err.Error())
+	}
+
+	// Unlike a rename, linking fails if the destination exists
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("could not write key: '%s' already exists", path)
+		}
+		return fmt.Errorf("could not write key to '%s': %s", path, err.Error())
+	}
+
+	return nil
+}
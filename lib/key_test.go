@@ -3,6 +3,13 @@ package lib
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -53,3 +60,80 @@ func Test_GeneratePSK(t *testing.T) {
 
 	rand.Reader = r
 }
+
+func Test_WriteKeyFile(t *testing.T) {
+	defer os.Remove("/tmp/testing-written.key")
+	os.Remove("/tmp/testing-written.key")
+
+	mask := syscall.Umask(0)
+	defer syscall.Umask(mask)
+
+	assert.Nil(t, WriteKeyFile("/tmp/testing-written.key", []byte("key\n")))
+
+	info, err := os.Stat("/tmp/testing-written.key")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	assert.NotNil(t, WriteKeyFile("/tmp/testing-written.key", []byte("other\n")))
+
+	data, _ := ioutil.ReadFile("/tmp/testing-written.key")
+	assert.Equal(t, "key\n", string(data))
+}
+
+func Test_CheckKeyFile(t *testing.T) {
+	createPKey(t)
+	defer os.Chmod("/tmp/testing.key", 0600)
+
+	assert.Nil(t, CheckKeyFile("/tmp/testing.key"))
+
+	os.Chmod("/tmp/testing.key", 0640)
+	assert.True(t, errors.Is(CheckKeyFile("/tmp/testing.key"), ErrKeyFileAccessible))
+
+	_, err := ParseConfigReader(strings.NewReader(minimalConfigYAML))
+	assert.True(t, errors.Is(err, ErrKeyFileAccessible))
+
+	if group, err := user.LookupGroup(NetworkdGroup); err == nil && os.Getuid() == 0 {
+		gid, _ := strconv.Atoi(group.Gid)
+		os.Chown("/tmp/testing.key", 0, gid)
+		defer os.Chown("/tmp/testing.key", 0, 0)
+
+		assert.Nil(t, CheckKeyFile("/tmp/testing.key"))
+
+		os.Chmod("/tmp/testing.key", 0660)
+		assert.True(t, errors.Is(CheckKeyFile("/tmp/testing.key"), ErrKeyFileAccessible))
+	}
+}
+
+func Test_ParsePresharedKey(t *testing.T) {
+	hexKey, err := ParsePresharedKey("4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1")
+	assert.Nil(t, err)
+
+	b64Key, err := ParsePresharedKey("TcwsdLIzh9sJv8Y18s3tZes3Xbm9VaZKjF8Y0mRB28E=")
+	assert.Nil(t, err)
+	assert.Equal(t, hexKey, b64Key)
+
+	_, err = ParsePresharedKey("4dcc2c74b23387db09bfc635f2cded65")
+	assert.NotNil(t, err)
+}
+
+func Test_InspectKey(t *testing.T) {
+	priv, _ := GeneratePrivateKey()
+	info, err := InspectKey(priv.String())
+	assert.Nil(t, err)
+	assert.Equal(t, KeyEncodingBase64, info.Encoding)
+	assert.Equal(t, KeyKindMaybePrivate, info.Kind)
+	assert.True(t, info.Clamped)
+
+	info, err = InspectKey("7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=")
+	assert.Nil(t, err)
+	assert.Equal(t, KeyKindPublicOrPSK, info.Kind)
+	assert.False(t, info.Clamped)
+
+	info, err = InspectKey("4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1")
+	assert.Nil(t, err)
+	assert.Equal(t, KeyEncodingHex, info.Encoding)
+	assert.Equal(t, KeyKindPSK, info.Kind)
+
+	_, err = InspectKey("not a key")
+	assert.NotNil(t, err)
+}
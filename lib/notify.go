@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notify sends a state update (e.g. READY=1) to the service manager through the datagram
+// socket given in the NOTIFY_SOCKET environment variable. It returns false without error
+// when the process is not supervised by a manager supporting the sd_notify protocol.
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// Abstract namespace sockets are represented with a leading '@'
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("could not connect to notification socket: %s", err.Error())
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, fmt.Errorf("could not send notification: %s", err.Error())
+	}
+
+	return true, nil
+}
+
+// WatchdogInterval returns the interval at which the service manager expects keep-alive
+// notifications, as given by the WATCHDOG_USEC environment variable, or zero if the watchdog
+// is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Notify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+
+	sent, err := Notify("READY=1")
+	assert.Nil(t, err)
+	assert.False(t, sent)
+
+	dir, _ := ioutil.TempDir("", "wgctl")
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	sent, err = Notify("READY=1", "STATUS=1 peer(s)")
+	assert.Nil(t, err)
+	assert.True(t, sent)
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "READY=1\nSTATUS=1 peer(s)", string(buf[:n]))
+
+	os.Setenv("NOTIFY_SOCKET", filepath.Join(dir, "missing.sock"))
+
+	_, err = Notify("READY=1")
+	assert.NotNil(t, err)
+}
+
+func Test_WatchdogInterval(t *testing.T) {
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	assert.Equal(t, time.Duration(0), WatchdogInterval())
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	assert.Equal(t, 30*time.Second, WatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	assert.Equal(t, 30*time.Second, WatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", "1")
+	assert.Equal(t, time.Duration(0), WatchdogInterval())
+
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+}
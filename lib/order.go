@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+)
+
+// StartOrder sorts instances into successive waves so that every instance comes after the
+// instances listed in its 'after' directive. Instances within a wave do not depend on each
+// other and can be brought up concurrently. Dependencies are included even if they were not
+// requested.
+func StartOrder(configs map[string]*Config, instances []string) ([][]string, error) {
+	depths := make(map[string]int)
+	visiting := make(map[string]bool)
+
+	var visit func(string) (int, error)
+	visit = func(instance string) (int, error) {
+		if depth, ok := depths[instance]; ok {
+			return depth, nil
+		}
+		if visiting[instance] {
+			return 0, fmt.Errorf("dependency cycle detected on tunnel '%s'", instance)
+		}
+
+		config, ok := configs[instance]
+		if !ok {
+			return 0, fmt.Errorf("unknown tunnel '%s'", instance)
+		}
+
+		visiting[instance] = true
+
+		depth := 0
+		for _, dep := range config.After {
+			d, err := visit(dep)
+			if err != nil {
+				return 0, err
+			}
+			if d+1 > depth {
+				depth = d + 1
+			}
+		}
+
+		visiting[instance] = false
+		depths[instance] = depth
+
+		return depth, nil
+	}
+
+	for _, instance := range instances {
+		if _, err := visit(instance); err != nil {
+			return nil, err
+		}
+	}
+
+	waves := make([][]string, 0)
+	for instance, depth := range depths {
+		for len(waves) <= depth {
+			waves = append(waves, []string{})
+		}
+		waves[depth] = append(waves[depth], instance)
+	}
+	for _, wave := range waves {
+		sort.Strings(wave)
+	}
+
+	return waves, nil
+}
+
+// StopOrder returns the waves of StartOrder in reverse, so that tunnels are torn down before
+// the tunnels they depend on.
+func StopOrder(configs map[string]*Config, instances []string) ([][]string, error) {
+	waves, err := StartOrder(configs, instances)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(waves)-1; i < j; i, j = i+1, j-1 {
+		waves[i], waves[j] = waves[j], waves[i]
+	}
+
+	return waves, nil
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StartOrder(t *testing.T) {
+	configs := map[string]*Config{
+		"uplink":  {},
+		"office":  {After: []string{"uplink"}},
+		"lab":     {After: []string{"uplink"}},
+		"nested":  {After: []string{"office", "lab"}},
+		"unused":  {},
+		"orphans": {},
+	}
+
+	waves, err := StartOrder(configs, []string{"nested", "orphans"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"orphans", "uplink"}, {"lab", "office"}, {"nested"}}, waves)
+
+	waves, err = StopOrder(configs, []string{"nested", "orphans"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"nested"}, {"lab", "office"}, {"orphans", "uplink"}}, waves)
+}
+
+func Test_StartOrderErrors(t *testing.T) {
+	configs := map[string]*Config{
+		"a": {After: []string{"b"}},
+		"b": {After: []string{"a"}},
+		"c": {After: []string{"missing"}},
+	}
+
+	_, err := StartOrder(configs, []string{"a"})
+	assert.NotNil(t, err)
+
+	_, err = StartOrder(configs, []string{"c"})
+	assert.NotNil(t, err)
+
+	waves, err := StartOrder(configs, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(waves))
+}
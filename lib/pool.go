@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// AllocateAddresses returns, for each prefix of the address pool, the first host address that
+// is not used by any peer, either as its address or within its allowed IPs. Allowed IPs at
+// least as wide as a pool prefix (e.g. the peer routing the whole pool) are not considered as
+// using it.
+func (c *Config) AllocateAddresses() ([]IPMask, error) {
+	if len(c.Pool) == 0 {
+		return nil, fmt.Errorf("no address 'pool' is defined")
+	}
+
+	peers := c.Peers
+	if c.Self != nil {
+		peers = append([]*Peer{c.Self}, c.Peers...)
+	}
+
+	addrs := make([]IPMask, len(c.Pool))
+	for idx, prefix := range c.Pool {
+		pool := net.IPNet(prefix)
+		ones, bits := pool.Mask.Size()
+
+		used := make([]net.IPNet, 0)
+		for _, p := range peers {
+			if p.Address != nil && pool.Contains(p.Address.IP) {
+				used = append(used, net.IPNet{IP: p.Address.IP, Mask: net.CIDRMask(bits, bits)})
+			}
+			for _, aip := range p.AllowedIPS {
+				if size, _ := aip.Mask.Size(); size > ones && pool.Contains(aip.IP) {
+					used = append(used, net.IPNet(aip))
+				}
+			}
+		}
+
+		ip, err := nextFreeAddress(pool, used)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs[idx] = IPMask{IP: ip, Mask: ones}
+	}
+
+	return addrs, nil
+}
+
+func nextFreeAddress(pool net.IPNet, used []net.IPNet) (net.IP, error) {
+	ones, bits := pool.Mask.Size()
+
+	first := ipToInt(pool.IP)
+	last := new(big.Int).Add(first, new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)))
+	last.Sub(last, big.NewInt(1))
+
+	// The network address (and the broadcast address in IPv4) cannot be assigned to a host
+	if bits-ones > 1 {
+		first.Add(first, big.NewInt(1))
+		if bits == 32 {
+			last.Sub(last, big.NewInt(1))
+		}
+	}
+
+	candidate := first
+	for candidate.Cmp(last) <= 0 {
+		ip := intToIP(candidate, bits)
+
+		conflict := false
+		for _, sub := range used {
+			if sub.Contains(ip) {
+				// Skip over the whole subnet in use
+				size, _ := sub.Mask.Size()
+				end := new(big.Int).Add(ipToInt(sub.IP.Mask(sub.Mask)), new(big.Int).Lsh(big.NewInt(1), uint(bits-size)))
+				candidate = end
+				conflict = true
+				break
+			}
+		}
+
+		if !conflict {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("address pool %s is exhausted", pool.String())
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	if v4 := ip.To4(); v4 != nil {
+		return new(big.Int).SetBytes(v4)
+	}
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+func intToIP(i *big.Int, bits int) net.IP {
+	buf := i.Bytes()
+	ip := make(net.IP, bits/8)
+	copy(ip[len(ip)-len(buf):], buf)
+
+	return ip
+}
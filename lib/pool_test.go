@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getPool(t *testing.T, cidrs ...string) []IPNet {
+	t.Helper()
+
+	pool := make([]IPNet, len(cidrs))
+	for idx, cidr := range cidrs {
+		_, sub, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("invalid CIDR: %s", cidr)
+		}
+		pool[idx] = IPNet(*sub)
+	}
+	return pool
+}
+
+func Test_AllocateAddresses(t *testing.T) {
+	c := &Config{
+		Pool: getPool(t, "10.0.0.0/24", "fd00::/64"),
+		Self: &Peer{Address: &IPMask{IP: net.ParseIP("10.0.0.1"), Mask: 24}, AllowedIPS: getPool(t, "0.0.0.0/0", "10.0.0.0/24")},
+		Peers: []*Peer{
+			{AllowedIPS: getPool(t, "10.0.0.2/31", "fd00::1/128")},
+			{Address: &IPMask{IP: net.ParseIP("10.0.0.5"), Mask: 24}, AllowedIPS: getPool(t, "fd00::2/127")},
+		},
+	}
+
+	addrs, err := c.AllocateAddresses()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(addrs))
+	assert.Equal(t, "10.0.0.4/24", addrs[0].String())
+	assert.Equal(t, "fd00::4/64", addrs[1].String())
+
+	c.Peers = append(c.Peers, &Peer{AllowedIPS: getPool(t, "10.0.0.4/32")})
+	addrs, err = c.AllocateAddresses()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.6/24", addrs[0].String())
+}
+
+func Test_AllocateAddressesExhausted(t *testing.T) {
+	c := &Config{
+		Pool:  getPool(t, "10.0.0.0/30"),
+		Peers: []*Peer{{AllowedIPS: getPool(t, "10.0.0.1/32", "10.0.0.2/32")}},
+	}
+
+	_, err := c.AllocateAddresses()
+	assert.NotNil(t, err)
+
+	c = &Config{}
+	_, err = c.AllocateAddresses()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// RotatedKeyPath returns the path where a key replacing the one stored at path is written, next
+// to it and suffixed with the time of the rotation (e.g. vpn1.20200102150405.key).
+func RotatedKeyPath(path string, now time.Time) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(path, ext), now.Format("20060102150405"), ext)
+}
+
+// RotateKey generates a new private key for a configuration, writes it next to the current key
+// file, encrypted with the same passphrase if the current one is, and points the document to it.
+// Inline keys are replaced in the document itself. The public key of the self peer is changed
+// accordingly. It returns the path to the new key file, if any, and the new public key.
+func (f *ConfigFile) RotateKey(config *Config, now time.Time) (string, Key, error) {
+	path, ok := SecretFilePath(config.PrivateKey.Path)
+	if !ok && !config.PrivateKey.Inline() {
+		return "", nil, fmt.Errorf("only private keys stored in files or inline can be rotated")
+	}
+
+	priv, err := GeneratePrivateKey()
+	if err != nil {
+		return "", nil, err
+	}
+	pubkey := ComputePublicKey(priv.Data[:])
+
+	if _, err := f.UpdatePeer(config.Self.PublicKey.String(), map[string]string{"pubkey": pubkey.String()}); err != nil {
+		return "", nil, err
+	}
+
+	if config.PrivateKey.Inline() {
+		setMappingValue(f.doc.Content[0], "private_key", &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: priv.String()})
+
+		return "", pubkey, nil
+	}
+
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read private key: %s", err.Error())
+	}
+
+	data := []byte(priv.String() + "\n")
+	if IsEncryptedKey(current) {
+		passphrase, err := Passphrase(config.PrivateKey.Path)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not get passphrase: %s", err.Error())
+		}
+		if data, err = EncryptKey(priv.Data[:], passphrase); err != nil {
+			return "", nil, err
+		}
+	}
+
+	newPath := RotatedKeyPath(path, now)
+
+	if err := WriteKeyFile(newPath, data); err != nil {
+		return "", nil, err
+	}
+
+	setMappingValue(f.doc.Content[0], "private_key", &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: newPath})
+
+	return newPath, pubkey, nil
+}
+
+// RotatePresharedKeys sets a new preshared key on every peer of a configuration, except for
+// the self peer, keeping the encoding of the previous one. Peers defined in included files are
+// left alone, and returned.
+func (f *ConfigFile) RotatePresharedKeys(config *Config) ([]*IncludedPeerError, error) {
+	skipped := make([]*IncludedPeerError, 0)
+
+	for _, p := range config.Peers {
+		psk, err := GeneratePSK()
+		if err != nil {
+			return nil, err
+		}
+
+		previous := p.PresharedKey
+		p.PresharedKey = &psk
+
+		if _, err := f.UpdatePeer(p.PublicKey.String(), map[string]string{"psk": p.PresharedKeyString()}); err != nil {
+			var ierr *IncludedPeerError
+			if !errors.As(err, &ierr) {
+				return nil, err
+			}
+
+			p.PresharedKey = previous
+			skipped = append(skipped, ierr)
+		}
+	}
+
+	return skipped, nil
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RotatedKeyPath(t *testing.T) {
+	now := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	assert.Equal(t, "/etc/wireguard/vpn1.20200102150405.key", RotatedKeyPath("/etc/wireguard/vpn1.key", now))
+	assert.Equal(t, "/etc/wireguard/vpn1.20200102150405", RotatedKeyPath("/etc/wireguard/vpn1", now))
+}
+
+func Test_RotateKey(t *testing.T) {
+	createPKey(t)
+
+	f, err := ParseConfigFile("/tmp/testing.yml", []byte(minimalConfigWithPeerYAML))
+	assert.Nil(t, err)
+	config, err := f.Config()
+	assert.Nil(t, err)
+
+	path, pubkey, err := f.RotateKey(config, time.Now())
+	assert.Nil(t, err)
+	defer os.Remove(path)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	skipped, err := f.RotatePresharedKeys(config)
+	assert.Nil(t, err)
+	assert.Empty(t, skipped)
+
+	rotated, err := f.Config()
+	assert.Nil(t, err)
+	assert.Equal(t, path, rotated.PrivateKey.Path)
+	assert.Equal(t, pubkey.String(), rotated.Self.PublicKey.String())
+	assert.Equal(t, 23456, rotated.Self.ListenPort)
+	assert.Nil(t, rotated.Self.PresharedKey)
+	assert.Len(t, rotated.Peers, 1)
+	assert.Equal(t, "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", rotated.Peers[0].PublicKey.String())
+	assert.NotNil(t, rotated.Peers[0].PresharedKey)
+
+	_, _, err = f.RotateKey(&Config{PrivateKey: PrivateKey{Path: "env:WGCTL_TEST_KEY"}, Self: config.Self}, time.Now())
+	assert.NotNil(t, err)
+}
+
+func Test_RotateInlineKey(t *testing.T) {
+	yml := strings.Replace(minimalConfigYAML, "private_key: /tmp/testing.key", "private_key: {value: '7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4='}", 1)
+
+	f, err := ParseConfigFile("/tmp/testing.yml", []byte(yml))
+	assert.Nil(t, err)
+	config, err := f.Config()
+	assert.Nil(t, err)
+
+	path, pubkey, err := f.RotateKey(config, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, "", path)
+
+	rotated, err := f.Config()
+	assert.Nil(t, err)
+	assert.True(t, rotated.PrivateKey.Inline())
+	assert.Equal(t, pubkey.String(), rotated.Self.PublicKey.String())
+}
+
+func Test_RotateEncryptedKey(t *testing.T) {
+	key, _ := base64.StdEncoding.DecodeString("7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=")
+	data, _ := EncryptKey(key, []byte("passphrase"))
+
+	ioutil.WriteFile("/tmp/testing-encrypted.key", data, 0600)
+	defer os.Remove("/tmp/testing-encrypted.key")
+
+	os.Setenv("WGCTL_TEST_PASSPHRASE", "passphrase")
+	os.Setenv("WGCTL_PASSPHRASE", "env:WGCTL_TEST_PASSPHRASE")
+	defer os.Unsetenv("WGCTL_TEST_PASSPHRASE")
+	defer os.Unsetenv("WGCTL_PASSPHRASE")
+
+	yml := strings.Replace(minimalConfigWithPeerYAML, "/tmp/testing.key", "/tmp/testing-encrypted.key", 1)
+
+	f, err := ParseConfigFile("/tmp/testing.yml", []byte(yml))
+	assert.Nil(t, err)
+	config, err := f.Config()
+	assert.Nil(t, err)
+
+	path, pubkey, err := f.RotateKey(config, time.Now())
+	assert.Nil(t, err)
+	defer os.Remove(path)
+
+	rotatedData, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, IsEncryptedKey(rotatedData))
+
+	rotatedKey, err := DecryptKey(rotatedData, []byte("passphrase"))
+	assert.Nil(t, err)
+	rotatedPubkey := ComputePublicKey(rotatedKey)
+	assert.Equal(t, pubkey.String(), rotatedPubkey.String())
+
+	rotated, err := f.Config()
+	assert.Nil(t, err)
+	assert.Equal(t, path, rotated.PrivateKey.Path)
+	assert.Equal(t, pubkey.String(), rotated.Self.PublicKey.String())
+
+	// The previous key is kept, untouched
+	previous, err := ioutil.ReadFile("/tmp/testing-encrypted.key")
+	assert.Nil(t, err)
+	assert.Equal(t, data, previous)
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"net"
+)
+
+// DiffAllowedIPs returns the allowed IPs of a peer that are in next but not in prev, whose
+// routes are to be added, and those that are in prev but not in next, whose routes are to be
+// deleted.
+func DiffAllowedIPs(prev, next []net.IPNet) ([]net.IPNet, []net.IPNet) {
+	added := make([]net.IPNet, 0)
+	removed := make([]net.IPNet, 0)
+
+	for _, ip := range next {
+		if !containsIPNet(prev, ip) {
+			added = append(added, ip)
+		}
+	}
+	for _, ip := range prev {
+		if !containsIPNet(next, ip) {
+			removed = append(removed, ip)
+		}
+	}
+
+	return added, removed
+}
+
+func containsIPNet(ips []net.IPNet, ip net.IPNet) bool {
+	for _, other := range ips {
+		if other.String() == ip.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCatchAll returns whether an allowed IP routes all traffic (i.e. 0.0.0.0/0 or ::/0), which is
+// done through policy routing rather than with a plain route
+func IsCatchAll(ip net.IPNet) bool {
+	ones, _ := ip.Mask.Size()
+	return ones == 0
+}
+
+// CatchAllRemoved returns whether the policy routing set up for catch-all allowed IPs is not
+// needed anymore: some of the removed allowed IPs were catch-all, and none of the remaining
+// ones are.
+func CatchAllRemoved(removed []net.IPNet, remaining ...[]net.IPNet) bool {
+	found := false
+	for _, ip := range removed {
+		if IsCatchAll(ip) {
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+
+	for _, ips := range remaining {
+		for _, ip := range ips {
+			if IsCatchAll(ip) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
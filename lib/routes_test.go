@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DiffAllowedIPs(t *testing.T) {
+	_, sub1, _ := net.ParseCIDR("10.0.0.0/24")
+	_, sub2, _ := net.ParseCIDR("10.0.1.0/24")
+	_, sub3, _ := net.ParseCIDR("0.0.0.0/0")
+
+	added, removed := DiffAllowedIPs([]net.IPNet{*sub1, *sub2}, []net.IPNet{*sub2, *sub3})
+
+	assert.Equal(t, []net.IPNet{*sub3}, added)
+	assert.Equal(t, []net.IPNet{*sub1}, removed)
+
+	added, removed = DiffAllowedIPs(nil, []net.IPNet{*sub1})
+
+	assert.Equal(t, []net.IPNet{*sub1}, added)
+	assert.Equal(t, 0, len(removed))
+
+	added, removed = DiffAllowedIPs([]net.IPNet{*sub1}, []net.IPNet{*sub1})
+
+	assert.Equal(t, 0, len(added))
+	assert.Equal(t, 0, len(removed))
+}
+
+func Test_CatchAllRemoved(t *testing.T) {
+	_, sub, _ := net.ParseCIDR("10.0.0.0/24")
+	_, all4, _ := net.ParseCIDR("0.0.0.0/0")
+	_, all6, _ := net.ParseCIDR("::/0")
+
+	assert.True(t, IsCatchAll(*all4))
+	assert.True(t, IsCatchAll(*all6))
+	assert.False(t, IsCatchAll(*sub))
+
+	assert.True(t, CatchAllRemoved([]net.IPNet{*sub, *all4}, []net.IPNet{*sub}))
+	assert.True(t, CatchAllRemoved([]net.IPNet{*all4}))
+	assert.False(t, CatchAllRemoved([]net.IPNet{*all4}, []net.IPNet{*sub}, []net.IPNet{*all6}))
+	assert.False(t, CatchAllRemoved([]net.IPNet{*sub}))
+}
@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Schemes of secret references
+const (
+	SecretFile       = "file"
+	SecretEnv        = "env"
+	SecretExec       = "exec"
+	SecretCredential = "credential"
+	SecretKeyring    = "keyring"
+)
+
+// ParseSecretRef splits a secret reference into its scheme and its value. References without
+// a known scheme are file paths.
+func ParseSecretRef(ref string) (string, string) {
+	if idx := strings.Index(ref, ":"); idx > 0 {
+		switch scheme := ref[:idx]; scheme {
+		case SecretFile, SecretEnv, SecretExec, SecretCredential, SecretKeyring:
+			return scheme, ref[idx+1:]
+		}
+	}
+
+	return SecretFile, ref
+}
+
+// IsSecretRef returns whether a value is a secret reference using an explicit scheme
+func IsSecretRef(value string) bool {
+	scheme, rest := ParseSecretRef(value)
+	return scheme != SecretFile || rest != value
+}
+
+// SecretFilePath returns the path of the file a secret reference reads from, if any. systemd
+// credentials are resolved from $CREDENTIALS_DIRECTORY.
+func SecretFilePath(ref string) (string, bool) {
+	scheme, value := ParseSecretRef(ref)
+
+	switch scheme {
+	case SecretFile:
+		return value, len(value) > 0
+	case SecretCredential:
+		dir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if len(dir) == 0 {
+			return "", false
+		}
+		return filepath.Join(dir, value), true
+	}
+
+	return "", false
+}
+
+// ResolveSecret reads the secret designated by a reference, which can be:
+//   - file:<path>, or a bare path: the content of a file
+//   - env:<name>: the value of an environment variable
+//   - exec:<command>: the standard output of a command, given with an absolute path
+//   - credential:<name>: a systemd credential, read from $CREDENTIALS_DIRECTORY
+//   - keyring:<description>: a user key from the session or user kernel keyring
+//
+// Leading and trailing whitespace is removed from the secret.
+func ResolveSecret(ref string) ([]byte, error) {
+	scheme, value := ParseSecretRef(ref)
+
+	var secret []byte
+	var err error
+
+	switch scheme {
+	case SecretFile, SecretCredential:
+		path, ok := SecretFilePath(ref)
+		if !ok {
+			return nil, fmt.Errorf("could not resolve '%s': $CREDENTIALS_DIRECTORY is not set", ref)
+		}
+
+		secret, err = ioutil.ReadFile(path)
+	case SecretEnv:
+		v, ok := os.LookupEnv(value)
+		if !ok {
+			return nil, fmt.Errorf("could not resolve '%s': environment variable is not set", ref)
+		}
+
+		secret = []byte(v)
+	case SecretExec:
+		secret, err = execSecret(value)
+	case SecretKeyring:
+		secret, err = keyringSecret(value)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve '%s': %w", ref, err)
+	}
+
+	return bytes.TrimSpace(secret), nil
+}
+
+func execSecret(command string) ([]byte, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 || !strings.HasPrefix(args[0], "/") {
+		return nil, fmt.Errorf("commands must be given with an absolute path")
+	}
+
+	stderr := new(bytes.Buffer)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+func keyringSecret(description string) ([]byte, error) {
+	var id int
+	var err error
+
+	for _, keyring := range []int{unix.KEY_SPEC_SESSION_KEYRING, unix.KEY_SPEC_USER_KEYRING} {
+		id, err = unix.KeyctlSearch(keyring, "user", description, 0)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not find key in keyring: %w", err)
+	}
+
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key from keyring: %w", err)
+	}
+
+	buf := make([]byte, size)
+	if _, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0); err != nil {
+		return nil, fmt.Errorf("could not read key from keyring: %w", err)
+	}
+
+	return buf, nil
+}
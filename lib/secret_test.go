@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseSecretRef(t *testing.T) {
+	scheme, value := ParseSecretRef("env:WG_KEY")
+	assert.Equal(t, SecretEnv, scheme)
+	assert.Equal(t, "WG_KEY", value)
+
+	scheme, value = ParseSecretRef("/etc/wireguard/vpn.key")
+	assert.Equal(t, SecretFile, scheme)
+	assert.Equal(t, "/etc/wireguard/vpn.key", value)
+
+	scheme, value = ParseSecretRef("c:/vpn.key")
+	assert.Equal(t, SecretFile, scheme)
+	assert.Equal(t, "c:/vpn.key", value)
+
+	assert.True(t, IsSecretRef("file:/etc/wireguard/vpn.key"))
+	assert.False(t, IsSecretRef("/etc/wireguard/vpn.key"))
+	assert.False(t, IsSecretRef("4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1"))
+}
+
+func Test_ResolveSecret(t *testing.T) {
+	createPKey(t)
+
+	secret, err := ResolveSecret("/tmp/testing.key")
+	assert.Nil(t, err)
+	assert.Equal(t, "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", string(secret))
+
+	secret, err = ResolveSecret("file:/tmp/testing.key")
+	assert.Nil(t, err)
+	assert.Equal(t, "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", string(secret))
+
+	os.Setenv("WGCTL_TEST_SECRET", " secret\n")
+	defer os.Unsetenv("WGCTL_TEST_SECRET")
+
+	secret, err = ResolveSecret("env:WGCTL_TEST_SECRET")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", string(secret))
+
+	_, err = ResolveSecret("env:WGCTL_TEST_MISSING")
+	assert.NotNil(t, err)
+
+	secret, err = ResolveSecret("exec:/bin/echo hello")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(secret))
+
+	_, err = ResolveSecret("exec:echo hello")
+	assert.NotNil(t, err)
+
+	_, err = ResolveSecret("exec:/bin/false")
+	assert.NotNil(t, err)
+}
+
+func Test_ResolveCredential(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "wgctl")
+	defer os.RemoveAll(dir)
+
+	_, err := ResolveSecret("credential:wg.key")
+	assert.NotNil(t, err)
+
+	ioutil.WriteFile(dir+"/wg.key", []byte("credential\n"), 0600)
+	os.Setenv("CREDENTIALS_DIRECTORY", dir)
+	defer os.Unsetenv("CREDENTIALS_DIRECTORY")
+
+	secret, err := ResolveSecret("credential:wg.key")
+	assert.Nil(t, err)
+	assert.Equal(t, "credential", string(secret))
+
+	path, ok := SecretFilePath("credential:wg.key")
+	assert.True(t, ok)
+	assert.Equal(t, dir+"/wg.key", path)
+
+	_, ok = SecretFilePath("env:WG_KEY")
+	assert.False(t, ok)
+}
+
+func Test_ParseConfigWithSecretRefs(t *testing.T) {
+	os.Setenv("WGCTL_TEST_KEY", "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=")
+	os.Setenv("WGCTL_TEST_PSK", "4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1")
+	defer os.Unsetenv("WGCTL_TEST_KEY")
+	defer os.Unsetenv("WGCTL_TEST_PSK")
+
+	c, err := ParseConfigReader(bytes.NewReader([]byte(`
+private_key: env:WGCTL_TEST_KEY
+peers:
+  - listen_port: 23456
+    public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+  - public_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+    preshared_key: env:WGCTL_TEST_PSK
+`)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "env:WGCTL_TEST_KEY", c.PrivateKey.Path)
+	assert.Equal(t, "4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1", c.Peers[0].PresharedKey.String())
+}
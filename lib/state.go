@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// Priorities of the policy routing rules set up for catch-all allowed IPs
+const (
+	SuppressRulePriority = 32000
+	FWMarkRulePriority   = 32001
+)
+
+// TunnelState is a comparable description of a WireGuard device and of the addresses, routes
+// and policy routing rules set up for it. It can be built from a configuration, to describe
+// what should be running, or from a live tunnel.
+type TunnelState struct {
+	PublicKey  string
+	ListenPort int
+	FWMark     int
+	Addresses  []string
+	Peers      []PeerState
+	Routes     []string
+	Rules      []string
+}
+
+// PeerState is the comparable description of a peer of a TunnelState
+type PeerState struct {
+	PublicKey    string
+	Description  string
+	Endpoint     string
+	PresharedKey bool
+	Keepalive    time.Duration
+	AllowedIPs   []string
+}
+
+// ExpectedState describes the tunnel a configuration should produce once brought up
+func ExpectedState(config *Config) *TunnelState {
+	priv := config.PrivateKey.Bytes()
+	pubkey := ComputePublicKey(priv[:])
+
+	state := &TunnelState{
+		PublicKey:  pubkey.String(),
+		ListenPort: config.Self.ListenPort,
+		FWMark:     config.Self.FWMark,
+		Addresses:  []string{},
+		Peers:      []PeerState{},
+		Routes:     []string{},
+		Rules:      []string{},
+	}
+
+	if config.Self.Address != nil {
+		state.Addresses = append(state.Addresses, config.Self.Address.String())
+	}
+
+	catchAll := false
+	for _, p := range config.Peers {
+		ps := PeerState{
+			PublicKey:    p.PublicKey.String(),
+			Description:  p.Description,
+			PresharedKey: p.PresharedKey != nil && len(*p.PresharedKey) > 0,
+			Keepalive:    p.KeepaliveInterval.Truncate(time.Second),
+			AllowedIPs:   []string{},
+		}
+		if p.Endpoint != nil {
+			ep, _ := p.Endpoint.MarshalYAML()
+			ps.Endpoint = ep.(string)
+		}
+
+		for _, ip := range p.AllowedIPS {
+			sub := net.IPNet(ip)
+			ps.AllowedIPs = append(ps.AllowedIPs, sub.String())
+
+			if config.Self.SetUpRoutes == nil || *config.Self.SetUpRoutes {
+				if ones, _ := sub.Mask.Size(); ones == 0 {
+					catchAll = true
+					state.Routes = append(state.Routes, FormatRoute(sub.String(), config.Self.ListenPort))
+				} else {
+					state.Routes = append(state.Routes, FormatRoute(sub.String(), 0))
+				}
+			}
+		}
+
+		state.Peers = append(state.Peers, ps)
+	}
+
+	if catchAll {
+		state.FWMark = config.Self.ListenPort
+		state.Rules = append(state.Rules,
+			FormatSuppressRule(),
+			FormatFWMarkRule(config.Self.ListenPort),
+		)
+	}
+
+	state.Sort()
+
+	return state
+}
+
+// FormatRoute returns the description of a route through a tunnel, in the given routing table
+// or in the main table if it is 0
+func FormatRoute(dst string, table int) string {
+	if table == 0 {
+		return dst
+	}
+	return fmt.Sprintf("%s table %d", dst, table)
+}
+
+// FormatSuppressRule returns the description of the rule looking up the main table while
+// ignoring its default routes
+func FormatSuppressRule() string {
+	return fmt.Sprintf("priority %d lookup main suppress_prefixlength 0", SuppressRulePriority)
+}
+
+// FormatFWMarkRule returns the description of the rule sending unmarked traffic to the routing
+// table of a tunnel
+func FormatFWMarkRule(table int) string {
+	return fmt.Sprintf("priority %d not fwmark %d lookup %d", FWMarkRulePriority, table, table)
+}
+
+// Sort orders all the lists of a state so that two states can be compared
+func (s *TunnelState) Sort() {
+	sort.Strings(s.Addresses)
+	sort.Strings(s.Routes)
+	sort.Strings(s.Rules)
+
+	for _, p := range s.Peers {
+		sort.Strings(p.AllowedIPs)
+	}
+	sort.Slice(s.Peers, func(i, j int) bool { return s.Peers[i].PublicKey < s.Peers[j].PublicKey })
+}
+
+// Lines renders a state as text, one property per line
+func (s *TunnelState) Lines() []string {
+	lines := []string{
+		"interface:",
+		fmt.Sprintf("  public_key: %s", s.PublicKey),
+		fmt.Sprintf("  listen_port: %d", s.ListenPort),
+		fmt.Sprintf("  fwmark: %d", s.FWMark),
+	}
+	for _, addr := range s.Addresses {
+		lines = append(lines, fmt.Sprintf("  address: %s", addr))
+	}
+
+	for _, p := range s.Peers {
+		header := fmt.Sprintf("peer %s:", p.PublicKey)
+		if len(p.Description) > 0 {
+			header = fmt.Sprintf("peer %s: # %s", p.PublicKey, p.Description)
+		}
+		lines = append(lines, header)
+
+		if len(p.Endpoint) > 0 {
+			lines = append(lines, fmt.Sprintf("  endpoint: %s", p.Endpoint))
+		}
+		if p.PresharedKey {
+			lines = append(lines, "  preshared_key: (set)")
+		}
+		if p.Keepalive > 0 {
+			lines = append(lines, fmt.Sprintf("  keepalive_interval: %s", p.Keepalive))
+		}
+		for _, ip := range p.AllowedIPs {
+			lines = append(lines, fmt.Sprintf("  allowed_ip: %s", ip))
+		}
+	}
+
+	for _, r := range s.Routes {
+		lines = append(lines, fmt.Sprintf("route %s", r))
+	}
+	for _, r := range s.Rules {
+		lines = append(lines, fmt.Sprintf("rule %s", r))
+	}
+
+	return lines
+}
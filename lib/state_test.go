@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ExpectedState(t *testing.T) {
+	createPKey(t)
+	c, err := ParseConfigReader(bytes.NewReader([]byte(catchAllConfigYAML)))
+	assert.Nil(t, err)
+
+	state := ExpectedState(c)
+
+	assert.Equal(t, "YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=", state.PublicKey)
+	assert.Equal(t, c.Self.ListenPort, state.FWMark)
+	assert.Equal(t, 2, len(state.Rules))
+	assert.Contains(t, state.Routes, FormatRoute("0.0.0.0/0", c.Self.ListenPort))
+	assert.Contains(t, state.Lines(), "rule "+FormatFWMarkRule(c.Self.ListenPort))
+}
+
+func Test_ExpectedStateWithoutRoutes(t *testing.T) {
+	createPKey(t)
+	c, err := ParseConfigReader(bytes.NewReader([]byte(fullConfigYAML)))
+	assert.Nil(t, err)
+
+	state := ExpectedState(c)
+
+	assert.Equal(t, 12345, state.FWMark)
+	assert.Equal(t, []string{"1.2.3.4/24"}, state.Addresses)
+	assert.Equal(t, 0, len(state.Routes))
+	assert.Equal(t, 2, len(state.Peers))
+	assert.True(t, state.Peers[1].PresharedKey)
+	assert.Equal(t, []string{"20.30.40.50/32", "50.40.30.0/24"}, state.Peers[1].AllowedIPs)
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const unitTemplate = `[Unit]
+Description=WireGuard tunnel {{.Instance}}{{with .Config.Description}} ({{.}}){{end}}
+Wants=network-online.target
+After=network-online.target{{range .Config.After}} wgctl@{{.}}.service{{end}}
+{{- if .Config.After}}
+Requires={{range $idx, $dep := .Config.After}}{{if $idx}} {{end}}wgctl@{{$dep}}.service{{end}}
+{{- end}}
+
+[Service]
+Type=notify
+NotifyAccess=main
+{{- if .Watchdog}}
+WatchdogSec={{.Watchdog}}
+{{- end}}
+Restart=on-failure
+Environment=WGCTL_CONFIG_PATH={{.ConfigPath}}
+ExecStart={{.Binary}} start -f {{.Instance}}
+
+CapabilityBoundingSet=CAP_NET_ADMIN
+AmbientCapabilities=CAP_NET_ADMIN
+NoNewPrivileges=yes
+ProtectSystem=strict
+# rp_filter is loosened when routing catch-all allowed IPs
+ReadWritePaths=/proc/sys/net
+ProtectHome=yes
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelModules=yes
+ProtectControlGroups=yes
+RestrictAddressFamilies=AF_UNIX AF_NETLINK AF_INET AF_INET6
+RestrictNamespaces=yes
+RestrictRealtime=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// UnitOptions holds the parameters of a generated systemd service unit
+type UnitOptions struct {
+	Binary   string
+	Watchdog time.Duration
+}
+
+// RenderSystemdUnit renders a hardened systemd service unit running a tunnel in the foreground
+func RenderSystemdUnit(instance string, config *Config, opts UnitOptions) (string, error) {
+	tpl, err := template.New("unit").Parse(unitTemplate)
+	if err != nil {
+		return "", fmt.Errorf("could not parse unit template: %s", err.Error())
+	}
+
+	watchdog := ""
+	if opts.Watchdog > 0 {
+		watchdog = fmt.Sprintf("%.0fs", opts.Watchdog.Seconds())
+	}
+
+	out := new(bytes.Buffer)
+	err = tpl.Execute(out, map[string]interface{}{
+		"Instance":   instance,
+		"Config":     config,
+		"Binary":     opts.Binary,
+		"Watchdog":   watchdog,
+		"ConfigPath": GetConfigPath(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not render unit: %s", err.Error())
+	}
+
+	return out.String(), nil
+}
+
+// RenderNetworkd renders the systemd-networkd .netdev and .network files equivalent to a
+// configuration, including the policy routing set up for catch-all allowed IPs.
+func RenderNetworkd(instance string, config *Config) (string, string, error) {
+	scheme, keyFile := ParseSecretRef(config.PrivateKey.Path)
+	if scheme != SecretFile || len(keyFile) == 0 {
+		return "", "", fmt.Errorf("the private key must be stored in a file")
+	}
+	if data, err := ioutil.ReadFile(keyFile); err == nil && IsEncryptedKey(data) {
+		return "", "", fmt.Errorf("the private key must not be encrypted, networkd cannot decrypt it")
+	}
+
+	catchAll := false
+	for _, p := range config.Peers {
+		for _, ip := range p.AllowedIPS {
+			if ones, _ := ip.Mask.Size(); ones == 0 {
+				catchAll = true
+			}
+		}
+	}
+
+	netdev := new(bytes.Buffer)
+	fmt.Fprintf(netdev, "[NetDev]\nName=%s\nKind=wireguard\n", instance)
+	if len(config.Description) > 0 {
+		fmt.Fprintf(netdev, "Description=%s\n", config.Description)
+	}
+
+	fmt.Fprintf(netdev, "\n[WireGuard]\nPrivateKeyFile=%s\nListenPort=%d\n", keyFile, config.Self.ListenPort)
+	if catchAll && *config.Self.SetUpRoutes {
+		fmt.Fprintf(netdev, "FirewallMark=%d\n", config.Self.ListenPort)
+	} else if config.Self.FWMark > 0 {
+		fmt.Fprintf(netdev, "FirewallMark=%d\n", config.Self.FWMark)
+	}
+
+	for _, p := range config.Peers {
+		fmt.Fprint(netdev, "\n[WireGuardPeer]\n")
+		if len(p.Description) > 0 {
+			fmt.Fprintf(netdev, "# %s\n", p.Description)
+		}
+		fmt.Fprintf(netdev, "PublicKey=%s\n", p.PublicKey.String())
+		if p.PresharedKey != nil && len(*p.PresharedKey) > 0 {
+			fmt.Fprintf(netdev, "PresharedKey=%s\n", base64.StdEncoding.EncodeToString(*p.PresharedKey))
+		}
+		if p.Endpoint != nil {
+			ep, _ := p.Endpoint.MarshalYAML()
+			fmt.Fprintf(netdev, "Endpoint=%s\n", ep)
+		}
+		if len(p.AllowedIPS) > 0 {
+			ips := make([]string, len(p.AllowedIPS))
+			for idx, ip := range p.AllowedIPS {
+				sub := net.IPNet(ip)
+				ips[idx] = sub.String()
+			}
+			fmt.Fprintf(netdev, "AllowedIPs=%s\n", strings.Join(ips, ","))
+		}
+		// WireGuard only supports keepalive intervals in whole seconds
+		if p.KeepaliveInterval >= time.Second {
+			fmt.Fprintf(netdev, "PersistentKeepalive=%.0f\n", p.KeepaliveInterval.Seconds())
+		}
+	}
+
+	network := new(bytes.Buffer)
+	fmt.Fprintf(network, "[Match]\nName=%s\n\n[Network]\n", instance)
+	if config.Self.Address != nil {
+		fmt.Fprintf(network, "Address=%s\n", config.Self.Address.String())
+	}
+
+	if *config.Self.SetUpRoutes {
+		for _, p := range config.Peers {
+			for _, ip := range p.AllowedIPS {
+				sub := net.IPNet(ip)
+				if ones, _ := sub.Mask.Size(); ones == 0 {
+					fmt.Fprintf(network, "\n[Route]\nDestination=%s\nTable=%d\n", sub.String(), config.Self.ListenPort)
+				} else {
+					fmt.Fprintf(network, "\n[Route]\nDestination=%s\n", sub.String())
+				}
+			}
+		}
+
+		if catchAll {
+			fmt.Fprint(network, "\n[RoutingPolicyRule]\nTable=main\nSuppressPrefixLength=0\nPriority=32000\n")
+			fmt.Fprintf(network, "\n[RoutingPolicyRule]\nFirewallMark=%d\nInvertRule=yes\nTable=%d\nPriority=32001\n", config.Self.ListenPort, config.Self.ListenPort)
+		}
+	}
+
+	return netdev.String(), network.String(), nil
+}
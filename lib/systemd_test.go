@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const catchAllConfigYAML = `
+description: Personal VPN
+private_key: /tmp/testing.key
+after: [uplink]
+peers:
+  - address: 10.0.0.2/32
+    listen_port: 23456
+    public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+  - description: Gateway
+    public_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+    preshared_key: 4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1
+    endpoint: 4.3.2.1:45000
+    keepalive_interval: 25s
+    allowed_ips:
+      - 10.0.0.0/24
+      - 0.0.0.0/0
+`
+
+func Test_RenderSystemdUnit(t *testing.T) {
+	createPKey(t)
+	c, err := ParseConfigReader(bytes.NewReader([]byte(catchAllConfigYAML)))
+	assert.Nil(t, err)
+
+	unit, err := RenderSystemdUnit("vpn", c, UnitOptions{Binary: "/usr/bin/wgctl", Watchdog: time.Minute})
+
+	assert.Nil(t, err)
+	assert.Contains(t, unit, "Description=WireGuard tunnel vpn (Personal VPN)\n")
+	assert.Contains(t, unit, "After=network-online.target wgctl@uplink.service\n")
+	assert.Contains(t, unit, "Requires=wgctl@uplink.service\n")
+	assert.Contains(t, unit, "Type=notify\n")
+	assert.Contains(t, unit, "WatchdogSec=60s\n")
+	assert.Contains(t, unit, "ExecStart=/usr/bin/wgctl start -f vpn\n")
+	// The foreground process tears the tunnel down itself, running stop again would run the hooks twice
+	assert.False(t, strings.Contains(unit, "ExecStop"))
+	assert.Contains(t, unit, "CapabilityBoundingSet=CAP_NET_ADMIN\n")
+
+	unit, err = RenderSystemdUnit("vpn", c, UnitOptions{Binary: "/usr/bin/wgctl"})
+
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(unit, "WatchdogSec"))
+}
+
+func Test_RenderNetworkd(t *testing.T) {
+	createPKey(t)
+	c, err := ParseConfigReader(bytes.NewReader([]byte(catchAllConfigYAML)))
+	assert.Nil(t, err)
+
+	netdev, network, err := RenderNetworkd("vpn", c)
+
+	assert.Nil(t, err)
+	assert.Contains(t, netdev, "Name=vpn\nKind=wireguard\n")
+	assert.Contains(t, netdev, "PrivateKeyFile=/tmp/testing.key\nListenPort=23456\nFirewallMark=23456\n")
+	assert.Contains(t, netdev, "PublicKey=7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=\n")
+	assert.Contains(t, netdev, "PresharedKey=TcwsdLIzh9sJv8Y18s3tZes3Xbm9VaZKjF8Y0mRB28E=\n")
+	assert.Contains(t, netdev, "Endpoint=4.3.2.1:45000\n")
+	assert.Contains(t, netdev, "AllowedIPs=10.0.0.0/24,0.0.0.0/0\n")
+	assert.Contains(t, netdev, "PersistentKeepalive=25\n")
+
+	assert.Contains(t, network, "Address=10.0.0.2/32\n")
+	assert.Contains(t, network, "[Route]\nDestination=10.0.0.0/24\n")
+	assert.Contains(t, network, "[Route]\nDestination=0.0.0.0/0\nTable=23456\n")
+	assert.Contains(t, network, "FirewallMark=23456\nInvertRule=yes\nTable=23456\n")
+
+	c.PrivateKey.Path = ""
+	_, _, err = RenderNetworkd("vpn", c)
+
+	assert.NotNil(t, err)
+}
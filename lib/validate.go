@@ -0,0 +1,262 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Severity is the importance of a validation finding
+type Severity string
+
+// Severities of validation findings
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// Finding is an issue detected in a configuration file
+type Finding struct {
+	File     string
+	Line     int
+	Severity Severity
+	Message  string
+}
+
+// String returns the representation of a finding, in the form of <file>:<line>: <message>
+func (f Finding) String() string {
+	if f.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", f.File, f.Line, f.Message)
+	}
+	return fmt.Sprintf("%s: %s", f.File, f.Message)
+}
+
+// Validate reads a configuration file and returns all issues found in it, along with the
+// parsed configuration if it could be parsed.
+func Validate(instance string) ([]Finding, *Config) {
+	path := GetConfigFile(instance)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []Finding{{File: path, Severity: SeverityError, Message: fmt.Sprintf("could not read configuration file: %s", err.Error())}}, nil
+	}
+
+	return ValidateData(path, data)
+}
+
+// ValidateData returns all issues found in YAML configuration data. Beyond the checks done when
+// parsing a configuration, it looks for conflicting allowed IPs and public keys, unreachable
+// pairs of peers, misplaced addresses, and unnecessary keepalives.
+func ValidateData(path string, data []byte) ([]Finding, *Config) {
+	findings := make([]Finding, 0)
+	add := func(line int, severity Severity, message string, args ...interface{}) {
+		findings = append(findings, Finding{File: path, Line: line, Severity: severity, Message: fmt.Sprintf(message, args...)})
+	}
+
+	f, err := ParseConfigFile(path, data)
+	if err != nil {
+		add(0, SeverityError, err.Error())
+		return findings, nil
+	}
+
+	config, err := parseConfig(path, data)
+	if err != nil {
+		var errs ConfigErrors
+		if !errors.As(err, &errs) {
+			add(0, SeverityError, err.Error())
+			return findings, nil
+		}
+
+		for _, fe := range errs {
+			// Invalid values of included files are reported in those files
+			file := path
+			if len(fe.File) > 0 {
+				file = fe.File
+			}
+			findings = append(findings, Finding{File: file, Line: fe.Line, Severity: SeverityError, Message: fmt.Sprintf("%s: %s", fe.Path, fe.Err.Error())})
+		}
+		sortFindings(findings)
+
+		return findings, nil
+	}
+
+	peers := append([]*Peer{config.Self}, config.Peers...)
+
+	keys := make(map[string]bool)
+	for _, p := range peers {
+		if keys[p.PublicKey.String()] {
+			add(f.peerLine(p, "public_key", -1), SeverityError, "public key '%s' is used by several peers", p.PublicKey.String())
+		}
+		keys[p.PublicKey.String()] = true
+	}
+
+	for i, p := range peers {
+		for j, aip := range p.AllowedIPS {
+			for _, other := range peers[i+1:] {
+				for _, oaip := range other.AllowedIPS {
+					a, b := net.IPNet(aip), net.IPNet(oaip)
+
+					if a.String() == b.String() {
+						add(f.peerLine(p, "allowed_ips", j), SeverityError, "allowed IP %s is shared by %s and %s", a.String(), peerName(p), peerName(other))
+					} else if a.Contains(b.IP) || b.Contains(a.IP) {
+						add(f.peerLine(p, "allowed_ips", j), SeverityInfo, "allowed IP %s of %s overlaps with %s of %s", a.String(), peerName(p), b.String(), peerName(other))
+					}
+				}
+			}
+		}
+	}
+
+	for _, p := range peers {
+		if p.Address == nil || len(p.AllowedIPS) == 0 {
+			continue
+		}
+
+		routed := false
+		for _, aip := range p.AllowedIPS {
+			sub := net.IPNet(aip)
+			if sub.Contains(p.Address.IP) {
+				routed = true
+			}
+		}
+
+		if !routed {
+			add(f.peerLine(p, "address", -1), SeverityWarning, "address %s of %s is outside of its allowed IPs, other peers will not route traffic to it", p.Address.IP, peerName(p))
+		}
+	}
+
+	for _, p := range config.Peers {
+		if config.Self.Endpoint == nil && p.Endpoint == nil {
+			add(f.peerLine(p, "public_key", -1), SeverityWarning, "neither this node nor %s has an endpoint, no connection can be initiated between them", peerName(p))
+		}
+
+		if config.Self.Endpoint != nil && isPublicIP(config.Self.Endpoint.IP) && p.KeepaliveInterval > 0 {
+			add(f.peerLine(p, "keepalive_interval", -1), SeverityInfo, "keepalive toward %s is unnecessary since this node has a public endpoint", peerName(p))
+		}
+	}
+
+	sortFindings(findings)
+
+	return findings, config
+}
+
+// ValidateListenPorts reports configurations, given by their file path, that would make several
+// tunnels listen on the same port.
+func ValidateListenPorts(configs map[string]*Config) []Finding {
+	findings := make([]Finding, 0)
+	ports := make(map[int][]string)
+
+	for path, config := range configs {
+		if config.Self != nil && config.Self.ListenPort > 0 {
+			ports[config.Self.ListenPort] = append(ports[config.Self.ListenPort], path)
+		}
+	}
+
+	for port, paths := range ports {
+		if len(paths) < 2 {
+			continue
+		}
+
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			line := 0
+			if data, err := ioutil.ReadFile(path); err == nil {
+				if f, err := ParseConfigFile(path, data); err == nil {
+					line = f.peerLine(configs[path].Self, "listen_port", -1)
+				}
+			}
+
+			findings = append(findings, Finding{File: path, Line: line, Severity: SeverityError, Message: fmt.Sprintf("listen port %d is used by several tunnels", port)})
+		}
+	}
+
+	sortFindings(findings)
+
+	return findings
+}
+
+// HasErrors returns whether any finding is an error
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Line < findings[j].Line
+	})
+}
+
+func peerName(p *Peer) string {
+	if len(p.Description) > 0 {
+		return fmt.Sprintf("'%s'", p.Description)
+	}
+	return fmt.Sprintf("'%s'", p.PublicKey.String())
+}
+
+// peerLine returns the line of a directive of a peer, or of an item of that directive if idx
+// is not negative. It falls back to the line of the peer itself.
+func (f *ConfigFile) peerLine(p *Peer, field string, idx int) int {
+	peers := mappingValue(f.doc.Content[0], "peers")
+	if peers == nil {
+		return 0
+	}
+
+	for _, node := range peers.Content {
+		pk := mappingValue(node, "public_key")
+		if pk == nil || pk.Value != p.PublicKey.String() {
+			continue
+		}
+
+		value := mappingValue(node, field)
+		if value == nil {
+			return node.Line
+		}
+		if idx >= 0 && value.Kind == yaml3.SequenceNode && idx < len(value.Content) {
+			return value.Content[idx].Line
+		}
+		return f.line(node, field)
+	}
+
+	return 0
+}
+
+// line returns the line of a key in a mapping node
+func (f *ConfigFile) line(node *yaml3.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i].Line
+		}
+	}
+	return node.Line
+}
+
+var privateNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"}
+
+// isPublicIP returns whether an IP address is globally routable, and thus not behind NAT
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return false
+	}
+
+	for _, cidr := range privateNetworks {
+		_, sub, _ := net.ParseCIDR(cidr)
+		if sub.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const lintedConfigYAML = `description: Lorem ipsum dolor sit amet
+private_key: /tmp/testing.key
+peers:
+  - description: 'Server'
+    address: 10.0.1.1/24
+    listen_port: 23456
+    public_key: YdgU1urK6hGr6WH+r5bTtB4qrung5odZ8OKImwhOo2Y=
+    endpoint: 1.2.3.4:23456
+    allowed_ips:
+      - 10.0.0.0/24
+  - description: 'Peer #1'
+    public_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+    keepalive_interval: 25s
+    allowed_ips:
+      - 10.0.0.2/32
+      - 192.168.0.0/24
+  - description: 'Peer #2'
+    public_key: uJtUEgdOFdszfiVbMVGdd7/la9k7P9+iUHRzJFtVfWc=
+    allowed_ips:
+      - 192.168.0.0/24
+`
+
+func findingAt(findings []Finding, line int, severity Severity) bool {
+	for _, f := range findings {
+		if f.Line == line && f.Severity == severity {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_ValidateData(t *testing.T) {
+	createPKey(t)
+	findings, config := ValidateData("/tmp/config.yml", []byte(lintedConfigYAML))
+	assert.NotNil(t, config)
+
+	assert.True(t, findingAt(findings, 5, SeverityWarning))
+	assert.True(t, findingAt(findings, 10, SeverityInfo))
+	assert.True(t, findingAt(findings, 13, SeverityInfo))
+	assert.True(t, findingAt(findings, 16, SeverityError))
+	assert.False(t, findingAt(findings, 0, SeverityWarning))
+	assert.True(t, HasErrors(findings))
+
+	assert.Equal(t, "/tmp/config.yml:5", findings[0].String()[:len("/tmp/config.yml:5")])
+}
+
+func Test_ValidateSharedAllowedIPs(t *testing.T) {
+	createPKey(t)
+	findings, _ := ValidateData("/tmp/config.yml", []byte(editableConfigYAML))
+	assert.Equal(t, 0, len(findings))
+
+	findings, _ = ValidateData("/tmp/config.yml", []byte(fullConfigYAML))
+	assert.True(t, findingAt(findings, 18, SeverityError))
+	assert.True(t, findingAt(findings, 19, SeverityError))
+}
+
+func Test_ValidateKeyPermissions(t *testing.T) {
+	createPKey(t)
+	defer os.Chmod("/tmp/testing.key", 0600)
+
+	os.Chmod("/tmp/testing.key", 0644)
+
+	findings, config := ValidateData("/tmp/config.yml", []byte(editableConfigYAML))
+	assert.Nil(t, config)
+	assert.True(t, findingAt(findings, 3, SeverityError))
+}
+
+func Test_ValidateUnparsable(t *testing.T) {
+	findings, config := ValidateData("/tmp/config.yml", []byte("peers: [\n"))
+	assert.Nil(t, config)
+	assert.True(t, HasErrors(findings))
+}
+
+func Test_ValidateListenPorts(t *testing.T) {
+	configs := map[string]*Config{
+		"/tmp/a.yml": {Self: &Peer{ListenPort: 1000}},
+		"/tmp/b.yml": {Self: &Peer{ListenPort: 1000}},
+		"/tmp/c.yml": {Self: &Peer{ListenPort: 2000}},
+	}
+
+	findings := ValidateListenPorts(configs)
+	assert.Equal(t, 2, len(findings))
+	assert.Equal(t, "/tmp/a.yml", findings[0].File)
+	assert.Equal(t, "/tmp/b.yml", findings[1].File)
+}
+
+func Test_ValidateInvalidValues(t *testing.T) {
+	createPKey(t)
+	findings, config := ValidateData("/tmp/config.yml", []byte(invalidConfigYAML))
+	assert.Nil(t, config)
+	assert.Equal(t, 4, len(findings))
+	assert.True(t, findingAt(findings, 12, SeverityError))
+	assert.Equal(t, "/tmp/config.yml:12: peers[1].allowed_ips[1]: could not parse IP address: 10.0.0.300/32", findings[2].String())
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const base64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// VanitySearch looks for a private key whose base64-encoded public key starts with a prefix
+type VanitySearch struct {
+	Prefix     string
+	IgnoreCase bool
+
+	attempts uint64
+}
+
+// NewVanitySearch checks that a public key can start with prefix and returns a search for it
+func NewVanitySearch(prefix string, ignoreCase bool) (*VanitySearch, error) {
+	// The last character of an encoded key only holds 4 bits, followed by padding
+	if len(prefix) == 0 || len(prefix) >= base64.StdEncoding.EncodedLen(wgtypes.KeyLen)-1 {
+		return nil, fmt.Errorf("prefix must be between 1 and %d characters long", base64.StdEncoding.EncodedLen(wgtypes.KeyLen)-2)
+	}
+	for _, c := range prefix {
+		if !strings.ContainsRune(base64Alphabet, c) {
+			return nil, fmt.Errorf("'%c' cannot appear in a base64-encoded key", c)
+		}
+	}
+
+	if ignoreCase {
+		prefix = strings.ToLower(prefix)
+	}
+
+	return &VanitySearch{Prefix: prefix, IgnoreCase: ignoreCase}, nil
+}
+
+// Attempts returns the number of keys tried so far
+func (v *VanitySearch) Attempts() uint64 {
+	return atomic.LoadUint64(&v.attempts)
+}
+
+// Expected returns the average number of keys to try before finding a match
+func (v *VanitySearch) Expected() float64 {
+	expected := 1.0
+	for _, c := range v.Prefix {
+		if v.IgnoreCase && strings.ContainsRune("abcdefghijklmnopqrstuvwxyz", c) {
+			expected *= 32
+		} else {
+			expected *= 64
+		}
+	}
+	return expected
+}
+
+// Run tries random private keys on several goroutines until one matches or ctx is done
+func (v *VanitySearch) Run(ctx context.Context, workers int) (*PrivateKey, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("at least one worker is needed")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan *PrivateKey, workers)
+	errs := make(chan error, workers)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			k, err := v.search(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if k != nil {
+				found <- k
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	select {
+	case k, ok := <-found:
+		if ok {
+			return k, nil
+		}
+	case err := <-errs:
+		return nil, err
+	}
+
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+		return nil, ctx.Err()
+	}
+}
+
+func (v *VanitySearch) search(ctx context.Context) (*PrivateKey, error) {
+	priv := new([wgtypes.KeyLen]byte)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(wgtypes.KeyLen))
+
+	for ctx.Err() == nil {
+		if _, err := io.ReadFull(rand.Reader, priv[:]); err != nil {
+			return nil, fmt.Errorf("could not generate key: %s", err.Error())
+		}
+		clamp(priv)
+
+		base64.StdEncoding.Encode(encoded, ComputePublicKey(priv[:]))
+		atomic.AddUint64(&v.attempts, 1)
+
+		candidate := string(encoded[:len(v.Prefix)])
+		if v.IgnoreCase {
+			candidate = strings.ToLower(candidate)
+		}
+
+		if candidate == v.Prefix {
+			return &PrivateKey{Data: *priv}, nil
+		}
+	}
+
+	return nil, nil
+}
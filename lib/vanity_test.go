@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewVanitySearch(t *testing.T) {
+	_, err := NewVanitySearch("", false)
+	assert.NotNil(t, err)
+	_, err = NewVanitySearch("wg-1", false)
+	assert.NotNil(t, err)
+	_, err = NewVanitySearch(strings.Repeat("A", 43), false)
+	assert.NotNil(t, err)
+
+	v, err := NewVanitySearch("a1", false)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(64*64), v.Expected())
+
+	v, err = NewVanitySearch("A1", true)
+	assert.Nil(t, err)
+	assert.Equal(t, "a1", v.Prefix)
+	assert.Equal(t, float64(32*64), v.Expected())
+}
+
+func Test_VanitySearch(t *testing.T) {
+	v, _ := NewVanitySearch("w", true)
+
+	k, err := v.Run(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.True(t, v.Attempts() > 0)
+
+	pubkey := ComputePublicKey(k.Data[:])
+	assert.True(t, strings.HasPrefix(strings.ToLower(pubkey.String()), "w"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	v, _ = NewVanitySearch("wgctlwgctl", false)
+	_, err = v.Run(ctx, 2)
+	assert.Equal(t, context.Canceled, err)
+}
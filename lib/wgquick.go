@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// RenderWgQuick renders a configuration, from the point of view of its own node, in the INI
+// format used by wg-quick and the mobile WireGuard applications. The interface addresses
+// default to the address of the node if none are given.
+func RenderWgQuick(config *Config, addresses ...IPMask) string {
+	out := new(bytes.Buffer)
+
+	if len(addresses) == 0 && config.Self != nil && config.Self.Address != nil {
+		addresses = []IPMask{*config.Self.Address}
+	}
+
+	fmt.Fprint(out, "[Interface]\n")
+	if len(config.Description) > 0 {
+		fmt.Fprintf(out, "# %s\n", config.Description)
+	}
+	fmt.Fprintf(out, "PrivateKey = %s\n", config.PrivateKey.String())
+	if len(addresses) > 0 {
+		addrs := make([]string, len(addresses))
+		for idx, addr := range addresses {
+			addrs[idx] = addr.String()
+		}
+		fmt.Fprintf(out, "Address = %s\n", strings.Join(addrs, ", "))
+	}
+	if config.Self != nil && config.Self.ListenPort > 0 {
+		fmt.Fprintf(out, "ListenPort = %d\n", config.Self.ListenPort)
+	}
+	if config.Self != nil && config.Self.FWMark > 0 {
+		fmt.Fprintf(out, "FwMark = %d\n", config.Self.FWMark)
+	}
+
+	for _, p := range config.Peers {
+		fmt.Fprint(out, "\n[Peer]\n")
+		if len(p.Description) > 0 {
+			fmt.Fprintf(out, "# %s\n", p.Description)
+		}
+		fmt.Fprintf(out, "PublicKey = %s\n", p.PublicKey.String())
+		if p.PresharedKey != nil && len(*p.PresharedKey) > 0 {
+			fmt.Fprintf(out, "PresharedKey = %s\n", base64.StdEncoding.EncodeToString(*p.PresharedKey))
+		}
+		if p.Endpoint != nil {
+			ep, _ := p.Endpoint.MarshalYAML()
+			fmt.Fprintf(out, "Endpoint = %s\n", ep)
+		}
+		if len(p.AllowedIPS) > 0 {
+			ips := make([]string, len(p.AllowedIPS))
+			for idx, ip := range p.AllowedIPS {
+				sub := net.IPNet(ip)
+				ips[idx] = sub.String()
+			}
+			fmt.Fprintf(out, "AllowedIPs = %s\n", strings.Join(ips, ", "))
+		}
+		// WireGuard only supports keepalive intervals in whole seconds
+		if p.KeepaliveInterval >= time.Second {
+			fmt.Fprintf(out, "PersistentKeepalive = %.0f\n", p.KeepaliveInterval.Seconds())
+		}
+	}
+
+	return out.String()
+}
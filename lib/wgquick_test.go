@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const wgQuickConfig = `[Interface]
+# Lorem ipsum dolor sit amet
+PrivateKey = 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+Address = 1.2.3.4/24
+ListenPort = 23456
+FwMark = 12345
+
+[Peer]
+# Peer #1
+PublicKey = 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+PresharedKey = TcwsdLIzh9sJv8Y18s3tZes3Xbm9VaZKjF8Y0mRB28E=
+Endpoint = 4.3.2.1:45000
+AllowedIPs = 20.30.40.50/32, 50.40.30.0/24
+
+[Peer]
+# Peer #2
+PublicKey = 4X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=
+Endpoint = 4.3.2.1:45001
+AllowedIPs = 20.30.40.50/32, 50.40.30.0/24
+`
+
+func Test_RenderWgQuick(t *testing.T) {
+	createPKey(t)
+	c, err := ParseConfigReader(bytes.NewReader([]byte(fullConfigYAML)))
+	assert.Nil(t, err)
+
+	assert.Equal(t, wgQuickConfig, RenderWgQuick(c))
+
+	out := RenderWgQuick(c, IPMask{IP: net.ParseIP("10.0.0.2"), Mask: 24}, IPMask{IP: net.ParseIP("fd00::2"), Mask: 64})
+	assert.Contains(t, out, "Address = 10.0.0.2/24, fd00::2/64\n")
+}
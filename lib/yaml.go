@@ -2,13 +2,13 @@ package lib
 
 import (
 	"encoding/base64"
-	"encoding/hex"
 	"fmt"
-	"io/ioutil"
 	"net"
+	"os"
 	"strings"
 
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	yaml "gopkg.in/yaml.v2"
 )
 
 // UnmarshalYAML returns an IPMask from a YAML string
@@ -73,33 +73,68 @@ func (ip UDPAddr) MarshalYAML() (interface{}, error) {
 	return fmt.Sprintf("[%s]:%d", ip.IP.String(), ip.Port), nil
 }
 
-// UnmarshalYAML returns an private key from a YAML file path
+// UnmarshalYAML returns a private key from a YAML file path or secret reference, or from the
+// key itself given inline, either as a {value: <key>} mapping or as a base64 string
 func (k *PrivateKey) UnmarshalYAML(f func(interface{}) error) error {
 	b := new(string)
-	if err := f(b); err == nil {
-		if key, err := ioutil.ReadFile(*b); err == nil {
-			b64key := strings.TrimSpace(string(key))
-			key, err = base64.StdEncoding.DecodeString(b64key)
-			if err != nil || len(key) != wgtypes.KeyLen {
-				return fmt.Errorf("key is of invalid size")
-			}
+	if err := f(b); err != nil {
+		inline := struct {
+			Value string `yaml:"value"`
+		}{}
+		if err := f(&inline); err != nil || len(inline.Value) == 0 {
+			return fmt.Errorf("could not parse private key reference")
+		}
 
-			bk := new([wgtypes.KeyLen]byte)
-			copy(bk[:], key)
+		return k.setInline(inline.Value)
+	}
 
-			*k = PrivateKey{
-				Path: *b,
-				Data: *bk,
-			}
-			return nil
-		}
+	if isInlineKey(*b) {
+		return k.setInline(*b)
+	}
+
+	key, err := ReadPrivateKey(*b)
+	if err != nil {
+		return secretError{*b, err}
 	}
 
-	return fmt.Errorf("could not open private key file")
+	*k = PrivateKey{
+		Path: *b,
+		Data: key,
+	}
+	return nil
+}
+
+func (k *PrivateKey) setInline(value string) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != wgtypes.KeyLen {
+		return fmt.Errorf("inline private key is of invalid size")
+	}
+
+	*k = NewPrivateKey(key)
+	return nil
+}
+
+// isInlineKey returns whether a private_key directive looks like a base64-encoded key rather
+// than like a path to a file
+func isInlineKey(value string) bool {
+	if len(value) != base64.StdEncoding.EncodedLen(wgtypes.KeyLen) {
+		return false
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err != nil || len(key) != wgtypes.KeyLen {
+		return false
+	}
+	if _, err := os.Stat(value); err == nil {
+		return false
+	}
+	return true
 }
 
-// MarshalYAML returns the YAML string representation of a PrivateKeyFile
+// MarshalYAML returns the YAML string representation of a PrivateKey: its path or secret
+// reference, or the key itself if it was given inline
 func (k PrivateKey) MarshalYAML() (interface{}, error) {
+	if k.Inline() {
+		return k.String(), nil
+	}
 	return k.Path, nil
 }
 
@@ -125,13 +160,23 @@ func (k Key) MarshalYAML() (interface{}, error) {
 	return k.String(), nil
 }
 
-// UnmarshalYAML returns a PresharedKey from a YAML string
+// UnmarshalYAML returns a PresharedKey from a YAML string, in base64 or hex, or from the secret
+// it references
 func (k *PresharedKey) UnmarshalYAML(f func(interface{}) error) error {
 	b := new(string)
 	if err := f(b); err == nil {
-		key, err := hex.DecodeString(*b)
-		if err != nil || len(key) != wgtypes.KeyLen {
-			return fmt.Errorf("preshared key is of invalid size")
+		value := []byte(*b)
+		if IsSecretRef(*b) {
+			secret, err := ResolveSecret(*b)
+			if err != nil {
+				return secretError{*b, fmt.Errorf("could not open preshared key: %s", err.Error())}
+			}
+			value = secret
+		}
+
+		key, err := ParsePresharedKey(string(value))
+		if err != nil {
+			return err
 		}
 
 		*k = key
@@ -141,7 +186,36 @@ func (k *PresharedKey) UnmarshalYAML(f func(interface{}) error) error {
 	return fmt.Errorf("could not parse preshared key")
 }
 
-// MarshalYAML returns the YAML string representation of a PresharedKey
+// MarshalYAML returns the YAML string representation of a PresharedKey, in PSKEncoding
 func (k PresharedKey) MarshalYAML() (interface{}, error) {
-	return k.String(), nil
+	return k.Format(PSKEncoding), nil
+}
+
+// MarshalYAML returns the YAML representation of a Peer, with its preshared key in the encoding
+// it was configured in, or as the secret reference it was read from
+func (p Peer) MarshalYAML() (interface{}, error) {
+	type plain Peer
+
+	data, err := yaml.Marshal(plain(p))
+	if err != nil {
+		return nil, err
+	}
+
+	out := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+
+	for idx := range out {
+		if out[idx].Key != "preshared_key" {
+			continue
+		}
+		if len(p.PresharedKeyRef) > 0 {
+			out[idx].Value = p.PresharedKeyRef
+		} else {
+			out[idx].Value = p.PresharedKeyString()
+		}
+	}
+
+	return out, nil
 }
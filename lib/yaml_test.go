@@ -3,9 +3,12 @@ package lib
 import (
 	"fmt"
 	"net"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func Test_UnmarshalIPMask(t *testing.T) {
@@ -251,3 +254,70 @@ func Test_MarshalPSK(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, out, key.String())
 }
+
+func Test_MarshalPresharedKeyEncoding(t *testing.T) {
+	createPKey(t)
+
+	yml := strings.Replace(fullConfigYAML, "    endpoint: 4.3.2.1:45001\n", "    preshared_key: TcwsdLIzh9sJv8Y18s3tZes3Xbm9VaZKjF8Y0mRB28E=\n    endpoint: 4.3.2.1:45001\n", 1)
+
+	c, err := ParseConfigReader(strings.NewReader(yml))
+	assert.Nil(t, err)
+	assert.Equal(t, KeyEncodingHex, c.Peers[0].PresharedKeyEncoding)
+	assert.Equal(t, KeyEncodingBase64, c.Peers[1].PresharedKeyEncoding)
+
+	out, err := yaml.Marshal(c)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), "preshared_key: 4dcc2c74b23387db09bfc635f2cded65eb375db9bd55a64a8c5f18d26441dbc1\n")
+	assert.Contains(t, string(out), "preshared_key: TcwsdLIzh9sJv8Y18s3tZes3Xbm9VaZKjF8Y0mRB28E=\n")
+
+	defer func() { PSKEncoding = KeyEncodingHex }()
+	PSKEncoding = KeyEncodingBase64
+
+	c.Peers[0].PresharedKeyEncoding = ""
+	out, err = yaml.Marshal(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(string(out), "preshared_key: TcwsdLIzh9sJv8Y18s3tZes3Xbm9VaZKjF8Y0mRB28E=\n"))
+}
+
+func Test_MarshalPresharedKeyRef(t *testing.T) {
+	createPKey(t)
+
+	os.Setenv("WGCTL_TEST_PSK", "TcwsdLIzh9sJv8Y18s3tZes3Xbm9VaZKjF8Y0mRB28E=")
+	defer os.Unsetenv("WGCTL_TEST_PSK")
+
+	yml := strings.Replace(fullConfigYAML, "    endpoint: 4.3.2.1:45001\n", "    preshared_key: env:WGCTL_TEST_PSK\n    endpoint: 4.3.2.1:45001\n", 1)
+
+	c, err := ParseConfigReader(strings.NewReader(yml))
+	assert.Nil(t, err)
+	assert.Equal(t, "env:WGCTL_TEST_PSK", c.Peers[1].PresharedKeyRef)
+	assert.Equal(t, "TcwsdLIzh9sJv8Y18s3tZes3Xbm9VaZKjF8Y0mRB28E=", c.Peers[1].PresharedKey.Format(KeyEncodingBase64))
+
+	out, err := yaml.Marshal(c)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), "preshared_key: env:WGCTL_TEST_PSK\n")
+	assert.NotContains(t, string(out), "TcwsdLIzh9sJv8Y18s3tZes3Xbm9VaZKjF8Y0mRB28E=")
+}
+
+func Test_UnmarshalInlinePrivateKey(t *testing.T) {
+	yml := strings.Replace(minimalConfigYAML, "private_key: /tmp/testing.key", "private_key: {value: '7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4='}", 1)
+
+	c, err := ParseConfigReader(strings.NewReader(yml))
+	assert.Nil(t, err)
+	assert.True(t, c.PrivateKey.Inline())
+	assert.Equal(t, "7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", c.PrivateKey.String())
+
+	yml = strings.Replace(minimalConfigYAML, "private_key: /tmp/testing.key", "private_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=", 1)
+
+	c, err = ParseConfigReader(strings.NewReader(yml))
+	assert.Nil(t, err)
+	assert.True(t, c.PrivateKey.Inline())
+
+	out, err := yaml.Marshal(c)
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), "private_key: 7X78dxEtCqCzVTxFYnxCcjxviI1vzeTl13yq+7rdPD4=\n")
+
+	yml = strings.Replace(minimalConfigYAML, "private_key: /tmp/testing.key", "private_key: {value: 'c2hvcnQ='}", 1)
+
+	_, err = ParseConfigReader(strings.NewReader(yml))
+	assert.NotNil(t, err)
+}
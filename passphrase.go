@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/apognu/wgctl/lib"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// passphrases holds the passphrases entered on the terminal, by key reference
+var passphrases = make(map[string][]byte)
+
+// setUpPassphrase makes encrypted private keys prompt for their passphrase on the terminal when
+// it is not provided through WGCTL_PASSPHRASE. Passphrases are asked for once per key. When
+// stdin is not a terminal, encrypted keys can only be read with WGCTL_PASSPHRASE.
+func setUpPassphrase() {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return
+	}
+
+	fromEnv := lib.Passphrase
+
+	lib.Passphrase = func(ref string) ([]byte, error) {
+		if len(os.Getenv("WGCTL_PASSPHRASE")) > 0 {
+			return fromEnv(ref)
+		}
+		if passphrase, ok := passphrases[ref]; ok {
+			return passphrase, nil
+		}
+
+		passphrase, err := promptPassphrase(fmt.Sprintf("Passphrase for %s: ", ref), false)
+		if err != nil {
+			return nil, err
+		}
+		passphrases[ref] = passphrase
+
+		return passphrase, nil
+	}
+}
+
+// newPassphrase returns the passphrase a key should be encrypted with, from the secret
+// reference held by an environment variable or by prompting twice on the terminal
+func newPassphrase(env string) ([]byte, error) {
+	if ref := os.Getenv(env); len(ref) > 0 {
+		return lib.ResolveSecret(ref)
+	}
+
+	return promptPassphrase("New passphrase: ", true)
+}
+
+// promptPassphrase reads a passphrase from the terminal without echoing it
+func promptPassphrase(prompt string, confirm bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("cannot prompt for a passphrase outside of a terminal, set WGCTL_PASSPHRASE")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("could not read passphrase: %s", err.Error())
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		confirmation, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("could not read passphrase: %s", err.Error())
+		}
+		if !bytes.Equal(passphrase, confirmation) {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+
+	return passphrase, nil
+}
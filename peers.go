@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func addPeer(instance string, props map[string]string) {
+	f, err := lib.LoadConfigFile(instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := f.AddPeer(props); err != nil {
+		logrus.Fatal(err)
+	}
+
+	savePeerChange(instance, f, "", props["pubkey"])
+
+	Up("peer has been added to '%s'", lib.GetInstanceFromArg(instance))
+}
+
+func updatePeer(instance, match string, props map[string]string) {
+	f, err := lib.LoadConfigFile(instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	pubkey, err := f.UpdatePeer(match, props)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	newPubkey := pubkey
+	if pk, ok := props["pubkey"]; ok {
+		newPubkey = pk
+	}
+
+	removed := ""
+	if newPubkey != pubkey {
+		removed = pubkey
+	}
+
+	savePeerChange(instance, f, removed, newPubkey)
+
+	Up("peer '%s' has been updated in '%s'", match, lib.GetInstanceFromArg(instance))
+}
+
+func removePeer(instance, match string, live bool) {
+	if live {
+		pubkey := match
+		if _, err := parsePublicKey(match); err != nil {
+			config, err := lib.ParseConfig(instance)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			p, err := config.FindPeer(match)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+			pubkey = p.PublicKey.String()
+		}
+
+		if err := removeLivePeer(instance, pubkey); err != nil {
+			logrus.Fatal(err)
+		}
+
+		Down("peer '%s' has been removed from live tunnel '%s'", match, lib.GetInstanceFromArg(instance))
+		return
+	}
+
+	f, err := lib.LoadConfigFile(instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	pubkey, err := f.RemovePeer(match)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	savePeerChange(instance, f, pubkey, "")
+
+	Down("peer '%s' has been removed from '%s'", match, lib.GetInstanceFromArg(instance))
+}
+
+// removeLivePeer removes a peer and the routes to its allowed IPs from a live tunnel
+func removeLivePeer(instance, pubkey string) error {
+	if client := newDaemonClient(); client != nil {
+		return client.RemovePeer(instance, pubkey)
+	}
+
+	key, err := parsePublicKey(pubkey)
+	if err != nil {
+		return err
+	}
+
+	return wg.RemovePeer(context.Background(), instance, key)
+}
+
+// savePeerChange validates and writes an edited configuration, then applies the change on the
+// live tunnel if it is up: the removed peer is deleted and the changed peer is (re)configured.
+func savePeerChange(instance string, f *lib.ConfigFile, removed, changed string) {
+	config, err := f.Config()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := f.Save(); err != nil {
+		logrus.Fatal(err)
+	}
+
+	name := lib.GetInstanceFromArg(instance)
+	if tunnelState(name) != tunnelUp {
+		return
+	}
+
+	if len(removed) > 0 {
+		if err := removeLivePeer(instance, removed); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+
+	p := config.GetPeer(changed)
+	if p == nil {
+		return
+	}
+
+	if client := newDaemonClient(); client != nil {
+		if err := client.Sync(instance); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	if err := wg.UpdatePeer(context.Background(), name, config, p); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func parsePublicKey(pubkey string) (wgtypes.Key, error) {
+	bk, err := base64.StdEncoding.DecodeString(pubkey)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("could not decode public key: %s", err.Error())
+	}
+	return wgtypes.NewKey(bk)
+}
+
+func newPeer(instance, description, endpoint string, keepalive time.Duration, qr qrOptions) {
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	serverEndpoint := config.Self.Endpoint
+	if len(endpoint) > 0 {
+		addr, err := net.ResolveUDPAddr("udp", endpoint)
+		if err != nil {
+			logrus.Fatalf("could not parse UDP address '%s': %s", endpoint, err.Error())
+		}
+
+		ep := lib.UDPAddr(*addr)
+		serverEndpoint = &ep
+	}
+	if serverEndpoint == nil {
+		logrus.Fatal("this node has no endpoint the new peer could connect to, pass one with --endpoint")
+	}
+
+	addrs, err := config.AllocateAddresses()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	priv, err := lib.GeneratePrivateKey()
+	if err != nil {
+		logrus.Fatalf("could not generate private key: %s", err.Error())
+	}
+	psk, err := lib.GeneratePSK()
+	if err != nil {
+		logrus.Fatalf("could not generate preshared key: %s", err.Error())
+	}
+
+	privkey := priv.Bytes()
+	pubkey := lib.ComputePublicKey(privkey[:])
+
+	hosts := make([]string, len(addrs))
+	for idx, addr := range addrs {
+		hosts[idx] = lib.IPMask{IP: addr.IP, Mask: len(addr.IP) * 8}.String()
+	}
+
+	props := map[string]string{
+		"pubkey":     pubkey.String(),
+		"psk":        psk.Format(lib.PSKEncoding),
+		"address":    addrs[0].String(),
+		"allowedips": strings.Join(hosts, ","),
+	}
+	if len(description) > 0 {
+		props["description"] = description
+	}
+
+	f, err := lib.LoadConfigFile(instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := f.AddPeer(props); err != nil {
+		logrus.Fatal(err)
+	}
+
+	savePeerChange(instance, f, "", pubkey.String())
+
+	// The new peer reaches the whole pool through this node
+	server := &lib.Peer{
+		Description:       config.Description,
+		PublicKey:         lib.ComputePublicKey(config.PrivateKey.Data[:]),
+		PresharedKey:      &psk,
+		Endpoint:          serverEndpoint,
+		AllowedIPS:        config.Pool,
+		KeepaliveInterval: keepalive,
+	}
+
+	client := &lib.Config{
+		Description: description,
+		PrivateKey:  *priv,
+		Self:        &lib.Peer{},
+		Peers:       []*lib.Peer{server},
+	}
+
+	outputConfig(lib.RenderWgQuick(client, addrs...), qr)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/sirupsen/logrus"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const qrPNGSize = 512
+
+// qrOptions controls how a rendered configuration is output
+type qrOptions struct {
+	terminal bool
+	png      string
+	invert   bool
+}
+
+func render(instance string, qr qrOptions, expand bool) {
+	if expand {
+		renderExpanded(instance)
+		return
+	}
+
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	outputConfig(lib.RenderWgQuick(config), qr)
+}
+
+// renderExpanded prints the YAML configuration of an instance as it is parsed
+func renderExpanded(instance string) {
+	path := lib.GetConfigFile(instance)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		logrus.Fatalf("could not read configuration file: %s", err.Error())
+	}
+
+	data, err = lib.ExpandConfigFile(path, data)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	fmt.Print(string(data))
+}
+
+// outputConfig prints a wg-quick configuration as text, or as a QR code that can be scanned
+// by the mobile WireGuard applications, and optionally writes the QR code to a PNG file.
+func outputConfig(content string, qr qrOptions) {
+	if !qr.terminal && len(qr.png) == 0 {
+		fmt.Print(content)
+		return
+	}
+
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		logrus.Fatalf("could not generate QR code: %s", err.Error())
+	}
+
+	if qr.terminal {
+		fmt.Print(code.ToSmallString(qr.invert))
+	}
+
+	if len(qr.png) > 0 {
+		png, err := code.PNG(qrPNGSize)
+		if err != nil {
+			logrus.Fatalf("could not generate QR code: %s", err.Error())
+		}
+		// The QR code holds a private key, and gets the same protection as key files
+		if err := lib.WriteKeyFile(qr.png, png); err != nil {
+			logrus.Fatalf("could not write QR code: %s", err.Error())
+		}
+
+		Up("QR code written to '%s'", qr.png)
+	}
+}
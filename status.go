@@ -1,52 +1,123 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/apognu/wgctl/lib"
 	"github.com/apognu/wgctl/wireguard"
 	"github.com/sirupsen/logrus"
-	nl "github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
-func status(instance string, short bool, all bool) {
+const (
+	tunnelUp           = wireguard.StateUp
+	tunnelDown         = wireguard.StateDown
+	tunnelNotWireGuard = wireguard.StateNotWireGuard
+	tunnelDrifted      = wireguard.StateDrifted
+	tunnelUnmanaged    = wireguard.StateUnmanaged
+)
+
+func status(instance string, short, allInterfaces, drift bool) {
 	if instance == "" {
-		statusAll(short)
+		statusAll(short, allInterfaces, drift)
 		return
 	}
 
-	l, err := nl.LinkByName(instance)
-	if err != nil {
-		if !short {
-			Down("tunnel '%s' is down", instance)
-		}
-		if !all {
-			os.Exit(1)
+	state := tunnelDown
+	if client := newDaemonClient(); client != nil {
+		s, err := client.Status(instance, drift)
+		if err != nil {
+			logrus.Fatal(err)
 		}
-		return
+		state = s
+	} else {
+		state = inspectTunnel(instance, drift)
+	}
+
+	if !printStatus(instance, state, short) {
+		os.Exit(1)
 	}
+}
 
-	if l.Type() == wireguard.NetlinkName {
+// printStatus prints the state of a tunnel and returns whether it is running
+func printStatus(instance, state string, short bool) bool {
+	switch state {
+	case tunnelUp, tunnelDrifted, tunnelUnmanaged:
 		if short {
 			fmt.Printf("%s\n", instance)
-		} else {
+			return true
+		}
+
+		switch state {
+		case tunnelDrifted:
+			Up("tunnel '%s' is up (drifted), its configuration is not fully applied", instance)
+		case tunnelUnmanaged:
+			Up("interface '%s' is up (unmanaged), it has no configuration", instance)
+		default:
 			Up("tunnel '%s' is up and running", instance)
 		}
-	} else {
+		return true
+	case tunnelNotWireGuard:
 		if !short {
 			Down("interface '%s' does not seem to be a WireGuard device", instance)
 		}
-		if !all {
-			os.Exit(1)
+	default:
+		if !short {
+			Down("tunnel '%s' is down", instance)
 		}
 	}
+
+	return false
+}
+
+// tunnelState returns whether the link matching an instance is up and is a WireGuard device
+func tunnelState(instance string) string {
+	state, _ := wg.Status(context.Background(), instance)
+	return state
+}
+
+// inspectTunnel returns the state of a tunnel, telling apart the tunnels that have no
+// configuration and, if drift is set, the tunnels that drifted from it. Comparing a tunnel with
+// its configuration parses it, and thus resolves the secrets it references.
+func inspectTunnel(instance string, drift bool) string {
+	if !drift {
+		state := tunnelState(instance)
+		if state == tunnelUp {
+			if _, err := os.Stat(lib.GetConfigFile(instance)); os.IsNotExist(err) {
+				return tunnelUnmanaged
+			}
+		}
+		return state
+	}
+
+	state, err := wg.Inspect(context.Background(), instance)
+	if err != nil {
+		logrus.Warnf("could not compare '%s' with its configuration: %s", instance, err.Error())
+	}
+	return state
 }
 
-func statusAll(short bool) {
+func statusAll(short, allInterfaces, drift bool) {
+	if client := newDaemonClient(); client != nil {
+		statuses, err := client.StatusAll(allInterfaces, drift)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+		for _, s := range statuses {
+			printStatus(s.Name, s.State, short)
+		}
+		return
+	}
+
 	instances, err := filepath.Glob(fmt.Sprintf("%s/*.yml", lib.GetConfigPath()))
 	if err != nil {
 		logrus.Fatalf("could not enumerate your configurations: %s", err.Error())
@@ -55,23 +126,62 @@ func statusAll(short bool) {
 	for _, path := range instances {
 		i := lib.GetInstanceFromArg(path)
 
-		status(i, short, true)
+		printStatus(i, inspectTunnel(i, drift), short)
+	}
+
+	if allInterfaces {
+		unmanaged, err := wg.Unmanaged(context.Background())
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		for _, name := range unmanaged {
+			printStatus(name, tunnelUnmanaged, short)
+		}
 	}
 }
 
 func info(instance string) {
-	config, err := lib.ParseConfig(instance)
-	if err != nil {
-		logrus.Fatalf("could not parse configuration: %s", err.Error())
+	if client := newDaemonClient(); client != nil {
+		ti, err := client.Info(instance)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		printInfo(ti.Description, ti.Peers, ti.PSKEncodings, ti.Device)
+		return
 	}
-	dev, _, err := wireguard.GetDevice(instance)
+
+	ti, err := wg.Info(context.Background(), instance)
 	if err != nil {
-		logrus.Fatalf("could not retrieve device information: %s", err.Error())
+		logrus.Fatal(err)
 	}
 
-	description := "<no description provided>"
-	if len(config.Description) > 0 {
-		description = config.Description
+	printInfo(ti.Config.Description, peerDescriptions(ti.Config), pskEncodings(ti.Config), ti.Device)
+}
+
+// peerDescriptions maps the public keys of the peers of a configuration to their descriptions
+func peerDescriptions(config *lib.Config) map[string]string {
+	descriptions := make(map[string]string)
+	for _, p := range config.Peers {
+		descriptions[p.PublicKey.String()] = p.Description
+	}
+	return descriptions
+}
+
+// pskEncodings maps the public keys of the peers of a configuration to the encoding their
+// preshared key was configured in
+func pskEncodings(config *lib.Config) map[string]string {
+	encodings := make(map[string]string)
+	for _, p := range config.Peers {
+		encodings[p.PublicKey.String()] = p.PresharedKeyEncoding
+	}
+	return encodings
+}
+
+func printInfo(description string, peerDescriptions, pskEncodings map[string]string, dev *wgtypes.Device) {
+	if len(description) == 0 {
+		description = "<no description provided>"
 	}
 
 	PrintSection(0, "tunnel", description, tunnelColor)
@@ -83,10 +193,8 @@ func info(instance string) {
 	if len(dev.Peers) > 0 {
 		for _, p := range dev.Peers {
 			description := "<no description provided>"
-			if peerSpec := config.GetPeer(p.PublicKey.String()); peerSpec != nil {
-				if len(peerSpec.Description) > 0 {
-					description = peerSpec.Description
-				}
+			if len(peerDescriptions[p.PublicKey.String()]) > 0 {
+				description = peerDescriptions[p.PublicKey.String()]
 			}
 
 			PrintSection(1, "peer", description, peerColor)
@@ -100,7 +208,7 @@ func info(instance string) {
 				}
 			}
 
-			PrintAttr(2, "pre-shared key", FormatPSK(p.PresharedKey), p.PresharedKey != lib.EmptyPSK)
+			PrintAttr(2, "pre-shared key", FormatPSK(p.PresharedKey, pskEncodings[p.PublicKey.String()]), p.PresharedKey != lib.EmptyPSK)
 
 			if len(p.AllowedIPs) > 0 {
 				ips := make([]string, len(p.AllowedIPs))
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/sirupsen/logrus"
+)
+
+func generateSystemd(instance string, networkd bool, watchdog time.Duration, outputDir string) {
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	instance = lib.GetInstanceFromArg(instance)
+
+	files := make([]systemdFile, 0)
+
+	if networkd {
+		netdev, network, err := lib.RenderNetworkd(instance, config)
+		if err != nil {
+			logrus.Fatalf("could not render networkd files: %s", err.Error())
+		}
+
+		// .netdev files may contain preshared keys, and must only be readable by systemd-networkd
+		files = append(files, systemdFile{fmt.Sprintf("90-%s.netdev", instance), netdev, 0640, lib.NetworkdGroup})
+		files = append(files, systemdFile{fmt.Sprintf("90-%s.network", instance), network, 0644, ""})
+	} else {
+		binary, err := os.Executable()
+		if err != nil {
+			logrus.Fatalf("could not find wgctl binary: %s", err.Error())
+		}
+
+		unit, err := lib.RenderSystemdUnit(instance, config, lib.UnitOptions{Binary: binary, Watchdog: watchdog})
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		files = append(files, systemdFile{fmt.Sprintf("wgctl@%s.service", instance), unit, 0644, ""})
+	}
+
+	for _, file := range files {
+		if outputDir == "" {
+			fmt.Printf("# %s\n%s\n", file.name, file.content)
+			continue
+		}
+
+		path := filepath.Join(outputDir, file.name)
+		if err := writeSystemdFile(path, file); err != nil {
+			logrus.Fatalf("could not write '%s': %s", path, err.Error())
+		}
+
+		Up("wrote '%s'", path)
+	}
+}
+
+// systemdFile is a file generated by `systemd generate`, to be owned by root and by a group
+// if one is given
+type systemdFile struct {
+	name    string
+	content string
+	mode    os.FileMode
+	group   string
+}
+
+func writeSystemdFile(path string, file systemdFile) error {
+	gid := 0
+	if len(file.group) > 0 {
+		group, err := user.LookupGroup(file.group)
+		if err != nil {
+			return fmt.Errorf("could not find group '%s': %s", file.group, err.Error())
+		}
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return fmt.Errorf("invalid gid for group '%s': %s", file.group, group.Gid)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(file.content), file.mode); err != nil {
+		return err
+	}
+	// The mode of an existing file is not changed by WriteFile
+	if err := os.Chmod(path, file.mode); err != nil {
+		return err
+	}
+
+	return os.Chown(path, 0, gid)
+}
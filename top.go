@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	topSortRate      = "rate"
+	topSortName      = "name"
+	topSortHandshake = "handshake"
+
+	clearScreen = "\033[H\033[2J"
+)
+
+// topSample is a snapshot of the transfer counters of a peer at a given time
+type topSample struct {
+	at time.Time
+	rx int64
+	tx int64
+}
+
+// topPeer is a line of the `top` view
+type topPeer struct {
+	tunnel      string
+	description string
+	handshake   time.Time
+	rx          int64
+	tx          int64
+	rxRate      float64
+	txRate      float64
+}
+
+func top(sortBy string, interval, stale time.Duration) {
+	// Errors are only reported once the terminal is restored
+	if err := runTop(sortBy, interval, stale); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func runTop(sortBy string, interval, stale time.Duration) error {
+	nlcl, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("could not create wireguard client: %w", err)
+	}
+	defer nlcl.Close()
+
+	samples := make(map[string]topSample)
+	descriptions := make(map[string]map[string]string)
+	keys := make(chan byte)
+
+	if fd := int(os.Stdin.Fd()); terminal.IsTerminal(fd) {
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("could not set up terminal: %s", err.Error())
+		}
+		defer terminal.Restore(fd, state)
+
+		go readKeys(keys)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		devs, err := nlcl.Devices()
+		if err != nil {
+			fmt.Print(clearScreen)
+			return fmt.Errorf("could not list devices: %w", err)
+		}
+
+		peers := collectPeers(devs, descriptions, samples)
+
+		sortPeers(peers, sortBy)
+		renderTop(peers, sortBy, stale)
+
+		select {
+		case <-ticker.C:
+		case k, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+
+			switch k {
+			case 'q', 3:
+				fmt.Print(clearScreen)
+				return nil
+			case 'r':
+				sortBy = topSortRate
+			case 'n':
+				sortBy = topSortName
+			case 'h':
+				sortBy = topSortHandshake
+			}
+		}
+	}
+}
+
+func readKeys(keys chan<- byte) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(buf); err != nil {
+			close(keys)
+			return
+		}
+		keys <- buf[0]
+	}
+}
+
+// collectPeers lists the peers of all running tunnels and computes their throughput from the
+// previous sample, which is replaced by the current one. The descriptions of the peers of a
+// tunnel are only read the first time it is seen.
+func collectPeers(devs []*wgtypes.Device, descriptions map[string]map[string]string, samples map[string]topSample) []topPeer {
+	now := time.Now()
+	peers := make([]topPeer, 0)
+
+	for _, dev := range devs {
+		if _, ok := descriptions[dev.Name]; !ok {
+			descriptions[dev.Name], _ = lib.PeerDescriptions(dev.Name)
+		}
+
+		for _, p := range dev.Peers {
+			tp := topPeer{
+				tunnel:      dev.Name,
+				description: peerDescription(descriptions[dev.Name], p),
+				handshake:   p.LastHandshakeTime,
+				rx:          p.ReceiveBytes,
+				tx:          p.TransmitBytes,
+			}
+
+			id := fmt.Sprintf("%s/%s", dev.Name, p.PublicKey.String())
+			if prev, ok := samples[id]; ok {
+				tp.rxRate = computeRate(prev.rx, p.ReceiveBytes, now.Sub(prev.at))
+				tp.txRate = computeRate(prev.tx, p.TransmitBytes, now.Sub(prev.at))
+			}
+
+			samples[id] = topSample{at: now, rx: p.ReceiveBytes, tx: p.TransmitBytes}
+			peers = append(peers, tp)
+		}
+	}
+
+	return peers
+}
+
+func peerDescription(descriptions map[string]string, p wgtypes.Peer) string {
+	if description, ok := descriptions[p.PublicKey.String()]; ok {
+		return description
+	}
+	return p.PublicKey.String()
+}
+
+// computeRate returns the amount of bytes per second transferred between two counter values.
+// A counter going backwards (i.e. the tunnel was restarted) yields no throughput.
+func computeRate(prev, cur int64, elapsed time.Duration) float64 {
+	if cur < prev || elapsed <= 0 {
+		return 0
+	}
+	return float64(cur-prev) / elapsed.Seconds()
+}
+
+func sortPeers(peers []topPeer, sortBy string) {
+	sort.SliceStable(peers, func(i, j int) bool {
+		switch sortBy {
+		case topSortName:
+			if peers[i].tunnel != peers[j].tunnel {
+				return peers[i].tunnel < peers[j].tunnel
+			}
+			return peers[i].description < peers[j].description
+		case topSortHandshake:
+			return peers[i].handshake.After(peers[j].handshake)
+		default:
+			return peers[i].rxRate+peers[i].txRate > peers[j].rxRate+peers[j].txRate
+		}
+	})
+}
+
+func renderTop(peers []topPeer, sortBy string, stale time.Duration) {
+	out := new(bytes.Buffer)
+
+	fmt.Fprint(out, clearScreen)
+	fmt.Fprintf(out, "wgctl top - %s - sorted by %s - [r]ate [n]ame [h]andshake [q]uit\n\n", time.Now().Format("15:04:05"), sortBy)
+	attrKeyColor.Fprintf(out, "%-12s %-32s %-12s %-12s %-22s %s\n", "TUNNEL", "PEER", "↓ RATE", "↑ RATE", "TRANSFER", "HANDSHAKE")
+
+	for _, p := range peers {
+		handshake := "never"
+		if p.handshake.Year() > 1970 {
+			handshake = FormatInterval(p.handshake)
+		}
+
+		line := fmt.Sprintf("%-12s %-32s %-12s %-12s %-22s %s", truncate(p.tunnel, 12), truncate(p.description, 32), FormatRate(p.rxRate), FormatRate(p.txRate), FormatTransfer(p.rx, p.tx), handshake)
+
+		if time.Since(p.handshake) > stale {
+			errColor.Fprintln(out, line)
+		} else {
+			fmt.Fprintln(out, line)
+		}
+	}
+
+	// The terminal is in raw mode, carriage returns are not implied by line feeds
+	fmt.Print(strings.Replace(out.String(), "\n", "\r\n", -1))
+}
+
+func truncate(s string, length int) string {
+	if len([]rune(s)) > length {
+		return string([]rune(s)[:length-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_ComputeRate(t *testing.T) {
+	assert.Equal(t, float64(512), computeRate(1024, 2048, 2*time.Second))
+	assert.Equal(t, float64(0), computeRate(2048, 1024, time.Second))
+	assert.Equal(t, float64(0), computeRate(1024, 2048, 0))
+}
+
+func Test_SortPeers(t *testing.T) {
+	now := time.Now()
+	peers := []topPeer{
+		{tunnel: "vpn2", description: "alice", handshake: now.Add(-time.Minute), rxRate: 10, txRate: 10},
+		{tunnel: "vpn1", description: "bob", handshake: now, rxRate: 100},
+		{tunnel: "vpn1", description: "alice", handshake: now.Add(-time.Hour), txRate: 50},
+	}
+
+	sortPeers(peers, topSortRate)
+	assert.Equal(t, []string{"bob", "alice", "alice"}, topDescriptions(peers))
+	assert.Equal(t, "vpn1", peers[1].tunnel)
+
+	sortPeers(peers, topSortName)
+	assert.Equal(t, []string{"alice", "bob", "alice"}, topDescriptions(peers))
+	assert.Equal(t, "vpn2", peers[2].tunnel)
+
+	sortPeers(peers, topSortHandshake)
+	assert.Equal(t, []string{"bob", "alice", "alice"}, topDescriptions(peers))
+	assert.Equal(t, "vpn2", peers[1].tunnel)
+}
+
+func Test_CollectPeers(t *testing.T) {
+	key, _ := wgtypes.GeneratePrivateKey()
+	devs := []*wgtypes.Device{{Name: "vpn1", Peers: []wgtypes.Peer{{PublicKey: key.PublicKey(), ReceiveBytes: 100}}}}
+
+	descriptions := map[string]map[string]string{"vpn1": {key.PublicKey().String(): "alice"}}
+	samples := map[string]topSample{}
+
+	peers := collectPeers(devs, descriptions, samples)
+
+	assert.Equal(t, 1, len(peers))
+	assert.Equal(t, "alice", peers[0].description)
+	assert.Equal(t, float64(0), peers[0].rxRate)
+	assert.Equal(t, int64(100), samples["vpn1/"+key.PublicKey().String()].rx)
+}
+
+func topDescriptions(peers []topPeer) []string {
+	descriptions := make([]string, len(peers))
+	for idx, p := range peers {
+		descriptions[idx] = p.description
+	}
+	return descriptions
+}
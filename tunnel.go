@@ -1,12 +1,12 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
 	"os"
-	"os/exec"
 	"os/signal"
 	"strconv"
 	"strings"
@@ -20,62 +20,159 @@ import (
 	"github.com/apognu/wgctl/wireguard"
 )
 
+// handshakeTimeout is the age after which a WireGuard session is rejected without a new handshake
+const handshakeTimeout = 180 * time.Second
+
+// wg manages tunnels when no daemon is running
+var wg = wireguard.NewClient()
+
 func start(instance string, noRoutes, foreground bool) {
-	config, err := lib.ParseConfig(instance)
-	if err != nil {
-		logrus.Fatal(err)
+	if foreground {
+		name, err := wg.Start(context.Background(), instance, noRoutes)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		Up("tunnel '%s' has been brought up", name)
+
+		runForeground(instance, name)
+		return
 	}
-	instance = lib.GetInstanceFromArg(instance)
 
-	err = wireguard.AddDevice(instance, config)
+	name, err := startInstance(instance, noRoutes)
 	if err != nil {
 		logrus.Fatal(err)
 	}
-	err = wireguard.ConfigureDevice(instance, config, true)
-	if err != nil {
-		logrus.Fatal(err)
+
+	Up("tunnel '%s' has been brought up", name)
+}
+
+// runForeground supervises a tunnel until the process is asked to stop. When run under a
+// service manager supporting sd_notify, it signals readiness, reports the state of the peers
+// and sends watchdog keep-alives as long as the tunnel is healthy.
+func runForeground(instance, name string) {
+	sg := make(chan os.Signal, 1)
+	signal.Notify(sg, os.Interrupt, syscall.SIGTERM)
+
+	started := time.Now()
+	watchdog := lib.WatchdogInterval()
+
+	notifyStatus(name, started, false, "READY=1")
+
+	interval := watchdog / 2
+	if watchdog == 0 {
+		interval = 30 * time.Second
 	}
-	if !noRoutes && *config.Self.SetUpRoutes {
-		err = wireguard.AddDeviceRoutes(instance, config)
-		if err != nil {
-			logrus.Fatal(err)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			notifyStatus(name, started, watchdog > 0)
+		case <-sg:
+			lib.Notify("STOPPING=1")
+
+			if _, err := tearDown(context.Background(), instance); err != nil {
+				logrus.Fatal(err)
+			}
+
+			Down("tunnel '%s' has been torn down", name)
+			return
 		}
 	}
+}
+
+// notifyStatus reports the state of the peers of a tunnel to the service manager, along with
+// a watchdog keep-alive if requested and the tunnel is healthy.
+func notifyStatus(instance string, started time.Time, watchdog bool, states ...string) {
+	dev, _, err := wireguard.GetDevice(instance)
+	if err != nil {
+		states = append(states, "STATUS=device has disappeared")
+	} else {
+		active := 0
+		for _, p := range dev.Peers {
+			if time.Since(p.LastHandshakeTime) < handshakeTimeout {
+				active++
+			}
+		}
 
-	Up("tunnel '%s' has been brought up", instance)
+		states = append(states, fmt.Sprintf("STATUS=%d/%d peer(s) with a recent handshake", active, len(dev.Peers)))
 
-	if len(config.Self.PostUp) > 0 {
-		for _, cmdSpec := range config.Self.PostUp {
-			execute(cmdSpec)
+		if watchdog && (time.Since(started) < handshakeTimeout || tunnelHealthy(dev)) {
+			states = append(states, "WATCHDOG=1")
 		}
 	}
 
-	if foreground {
-		sg := make(chan os.Signal)
-		signal.Notify(sg, os.Interrupt, syscall.SIGTERM)
+	if _, err := lib.Notify(states...); err != nil {
+		logrus.Warn(err)
+	}
+}
 
-		<-sg
+// tunnelHealthy returns whether the peers expected to maintain a session (the ones with a
+// persistent keepalive) have had a recent handshake. Tunnels without such peers are deemed
+// healthy as long as their device exists.
+func tunnelHealthy(dev *wgtypes.Device) bool {
+	expected := false
+	for _, p := range dev.Peers {
+		if p.PersistentKeepaliveInterval > 0 {
+			expected = true
 
-		stop(instance)
+			if time.Since(p.LastHandshakeTime) < handshakeTimeout {
+				return true
+			}
+		}
 	}
+
+	return !expected
 }
 
 func stop(instance string) {
-	config, err := lib.ParseConfig(instance)
+	name, err := stopInstance(instance)
 	if err != nil {
 		logrus.Fatal(err)
 	}
-	instance = lib.GetInstanceFromArg(instance)
 
-	wireguard.DeleteDevice(instance)
+	Down("tunnel '%s' has been torn down", name)
+}
+
+// startInstance brings up a tunnel through the daemon if one is running, or directly
+func startInstance(instance string, noRoutes bool) (string, error) {
+	if client := newDaemonClient(); client != nil {
+		return client.Start(instance, noRoutes)
+	}
+	return wg.Start(context.Background(), instance, noRoutes)
+}
 
-	if len(config.Self.PreDown) > 0 {
-		for _, cmdSpec := range config.Self.PreDown {
-			execute(cmdSpec)
+// stopInstance tears down a tunnel through the daemon if one is running, or directly
+func stopInstance(instance string) (string, error) {
+	if client := newDaemonClient(); client != nil {
+		return client.Stop(instance)
+	}
+	return tearDown(context.Background(), instance)
+}
+
+// tearDown stops a tunnel, tolerating a device that is already gone
+func tearDown(ctx context.Context, instance string) (string, error) {
+	name, err := wg.Stop(ctx, instance)
+	if errors.Is(err, wireguard.ErrDeviceNotFound) {
+		return name, nil
+	}
+	return name, err
+}
+
+func syncTunnel(instance string) {
+	if client := newDaemonClient(); client != nil {
+		if err := client.Sync(instance); err != nil {
+			logrus.Fatal(err)
 		}
+		return
 	}
 
-	Down("tunnel '%s' has been torn down", instance)
+	if _, err := wg.Sync(context.Background(), instance); err != nil {
+		logrus.Fatal(err)
+	}
 }
 
 func set(instance string, props map[string]string) {
@@ -110,39 +207,57 @@ func set(instance string, props map[string]string) {
 		}
 	}
 
-	err := wireguard.SetDevice(instance, c, false)
+	err := wg.Set(context.Background(), instance, c)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 }
 
 func setPeers(instance string, props map[string]string, replace bool) {
+	if client := newDaemonClient(); client != nil {
+		err := client.SetPeer(instance, props, replace)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	p, err := parsePeerProps(props)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	err = wg.SetPeer(context.Background(), instance, p, replace)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// parsePeerProps builds a peer configuration from a list of key=value properties
+func parsePeerProps(props map[string]string) (wgtypes.PeerConfig, error) {
 	p := wgtypes.PeerConfig{}
 	for k, v := range props {
 		switch k {
 		case "pubkey":
 			bk, err := base64.StdEncoding.DecodeString(v)
 			if err != nil {
-				logrus.Fatalf("could not decode public key: %s", err.Error())
+				return p, fmt.Errorf("could not decode public key: %s", err.Error())
 			}
 			k := []byte(bk)
 
 			p.PublicKey, _ = wgtypes.NewKey(k)
 		case "psk":
-			bk, err := hex.DecodeString(v)
-			if err != nil {
-				logrus.Fatalf("could not decode preshared key: %s", err.Error())
-			}
-			k, err := wgtypes.NewKey(bk)
+			psk, err := lib.ParsePresharedKey(v)
 			if err != nil {
-				logrus.Fatalf("could not decode preshared key: %s", err.Error())
+				return p, fmt.Errorf("could not decode preshared key: %s", err.Error())
 			}
+			k := wgtypes.Key(psk.Bytes())
 
 			p.PresharedKey = &k
 		case "endpoint":
 			addr, err := net.ResolveUDPAddr("udp", v)
 			if err != nil {
-				logrus.Fatalf("could not parse UDP address '%s': %s", v, err.Error())
+				return p, fmt.Errorf("could not parse UDP address '%s': %s", v, err.Error())
 			}
 
 			p.Endpoint = addr
@@ -152,7 +267,7 @@ func setPeers(instance string, props map[string]string, replace bool) {
 			for idx, ip := range strs {
 				_, sub, err := net.ParseCIDR(ip)
 				if err != nil {
-					logrus.Fatalf("could not parse allowed IP '%s': %s", ip, err.Error())
+					return p, fmt.Errorf("could not parse allowed IP '%s': %s", ip, err.Error())
 				}
 				ips[idx] = *sub
 			}
@@ -161,7 +276,7 @@ func setPeers(instance string, props map[string]string, replace bool) {
 		case "keepalive":
 			ka, err := strconv.Atoi(v)
 			if err != nil {
-				logrus.Fatalf("could not parse keepalive interval '%s': %s", v, err.Error())
+				return p, fmt.Errorf("could not parse keepalive interval '%s': %s", v, err.Error())
 			}
 			dur := time.Duration(ka) * time.Second
 
@@ -169,33 +284,5 @@ func setPeers(instance string, props map[string]string, replace bool) {
 		}
 	}
 
-	c := wgtypes.Config{Peers: []wgtypes.PeerConfig{p}}
-
-	wireguard.SetDevice(instance, c, replace)
-}
-
-func execute(cmdSpec []string) {
-	if len(cmdSpec) == 0 {
-		return
-	}
-
-	if !strings.HasPrefix(cmdSpec[0], "/") {
-		logrus.Warn("ignoring lifecycle hook not using an absolute path")
-		return
-	}
-
-	var cmd *exec.Cmd
-	stderr := new(bytes.Buffer)
-	if len(cmdSpec) == 1 {
-		cmd = exec.Command(cmdSpec[0])
-	} else {
-		cmd = exec.Command(cmdSpec[0], cmdSpec[1:]...)
-	}
-
-	cmd.Stderr = stderr
-
-	err := cmd.Run()
-	if err != nil {
-		logrus.Warnf("lifecycle hook returned an error: %s", err.Error())
-	}
+	return p, nil
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+)
+
+var warnColor = color.New(color.FgYellow)
+
+// validate lints one or all configurations and exits with an error status if any of them
+// contains an error. Listen ports are always compared with the other configurations.
+func validate(instance string, all bool) {
+	paths, err := filepath.Glob(fmt.Sprintf("%s/*.yml", lib.GetConfigPath()))
+	if err != nil {
+		logrus.Fatalf("could not enumerate your configurations: %s", err.Error())
+	}
+
+	target := ""
+	if !all {
+		target = lib.GetConfigFile(instance)
+		paths = append(paths, target)
+	}
+
+	findings := make([]lib.Finding, 0)
+	configs := make(map[string]*lib.Config)
+
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		fileFindings, config := lib.Validate(path)
+		if config != nil {
+			configs[path] = config
+		}
+
+		if all || sameFile(path, target) {
+			findings = append(findings, fileFindings...)
+		}
+	}
+
+	for _, f := range lib.ValidateListenPorts(configs) {
+		if all || sameFile(f.File, target) {
+			findings = append(findings, f)
+		}
+	}
+
+	for _, f := range findings {
+		printFinding(f)
+	}
+
+	if lib.HasErrors(findings) {
+		os.Exit(1)
+	}
+	if len(findings) == 0 {
+		Up("no issue found")
+	}
+}
+
+func printFinding(f lib.Finding) {
+	c := attrKeyColor
+	switch f.Severity {
+	case lib.SeverityError:
+		c = errColor
+	case lib.SeverityWarning:
+		c = warnColor
+	}
+
+	fmt.Printf("%s %s\n", c.Sprintf("[%s]", f.Severity), f.String())
+}
+
+func sameFile(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return a == b
+	}
+	sb, err := os.Stat(b)
+	if err != nil {
+		return a == b
+	}
+	return os.SameFile(sa, sb)
+}
@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/apognu/wgctl/lib"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -19,26 +23,43 @@ func main() {
 	kp := kingpin.New("wgctl", "WireGuard control plane helper")
 	kp.HelpFlag.Short('h')
 	kp.UsageTemplate(kingpin.CompactUsageTemplate)
+	kp.Flag("psk-encoding", "encoding of the preshared keys written by wgctl, when not already configured").Envar("WGCTL_PSK_ENCODING").Default(lib.KeyEncodingHex).EnumVar(&lib.PSKEncoding, lib.KeyEncodingHex, lib.KeyEncodingBase64)
+	kpEnvFile := kp.Flag("env-file", "file of KEY=VALUE environment variables used to expand configurations").Envar("WGCTL_ENV_FILE").String()
 
 	kpStart := kp.Command("start", "Bring up a tunnel.").Alias("up").PreAction(requireRoot)
-	kpStartInstance := kpStart.Arg("instance", instanceDesc).Required().String()
+	kpStartInstance := kpStart.Arg("instance", instanceDesc).String()
+	kpStartAll := kpStart.Flag("all", "bring up all tunnels marked with 'autostart'").Short('a').Default("false").Bool()
 	kpStartNoRoutes := kpStart.Flag("no-routes", "do not set up routing").Default("false").Bool()
 	kpStartForeground := kpStart.Flag("foreground", "stay in the foreground").Short('f').Default("false").Bool()
 
 	kpStop := kp.Command("stop", "Tear down a tunnel.").Alias("down").PreAction(requireRoot)
-	kpStopInstance := kpStop.Arg("instance", instanceDesc).Required().String()
+	kpStopInstance := kpStop.Arg("instance", instanceDesc).String()
+	kpStopAll := kpStop.Flag("all", "tear down all active tunnels").Short('a').Default("false").Bool()
 
 	kpRestart := kp.Command("restart", "Restart a tunnel from its configuration.").PreAction(requireRoot)
 	kpRestartInstance := kpRestart.Arg("instance", instanceDesc).Required().String()
 	kpRestartNoRoutes := kpRestart.Flag("no-routes", "do not set up routing").Default("false").Bool()
 
+	kpSync := kp.Command("sync", "Re-apply a configuration on a live tunnel.").PreAction(requireRoot)
+	kpSyncInstance := kpSync.Arg("instance", instanceDesc).Required().String()
+
 	kpStatus := kp.Command("status", "Show tunnel status.").PreAction(requireRoot)
 	kpStatusInstance := kpStatus.Arg("instance", instanceDesc).String()
 	kpStatusShort := kpStatus.Flag("short", "only display the names of active tunnels").Short('s').Default("false").Bool()
+	kpStatusAllInterfaces := kpStatus.Flag("all-interfaces", "also display WireGuard interfaces without a configuration").Default("false").Bool()
+	kpStatusNoDrift := kpStatus.Flag("no-drift", "do not compare running tunnels with their configuration").Default("false").Bool()
 
 	kpInfo := kp.Command("info", "Get tunnel information.").PreAction(requireRoot)
 	kpInfoInstance := kpInfo.Arg("instance", "name of your WireGuard configuration").Required().String()
 
+	kpDiff := kp.Command("diff", "Show differences between a configuration and its running tunnel.").PreAction(requireRoot)
+	kpDiffInstance := kpDiff.Arg("instance", instanceDesc).Required().String()
+
+	kpTop := kp.Command("top", "Show live transfer rates of all active tunnels.").PreAction(requireRoot)
+	kpTopSort := kpTop.Flag("sort", "initial sort order").Short('s').Default(topSortRate).Enum(topSortRate, topSortName, topSortHandshake)
+	kpTopInterval := kpTop.Flag("interval", "refresh interval").Short('i').Default("1s").Duration()
+	kpTopStale := kpTop.Flag("stale", "age after which a handshake is highlighted as stale").Default("3m").Duration()
+
 	kpSet := kp.Command("set", "Set live tunnel properties").PreAction(requireRoot)
 	kpSetInstance := kpSet.Arg("instance", "name of your WireGuard configuration").Required().String()
 	kpSetParameters := kpSet.Arg("settings", "list of key=value to apply to the wireguard interface").StringMap()
@@ -53,44 +74,160 @@ func main() {
 	kpPeerReplaceInstance := kpPeerReplace.Arg("instance", "name of your WireGuard configuration").Required().String()
 	kpPeerReplacePeer := kpPeerReplace.Arg("peer", "list of key=value to the new peer").Required().StringMap()
 
+	kpPeerAdd := kpPeer.Command("add", "add a peer to a configuration and to its live tunnel").PreAction(requireRoot)
+	kpPeerAddInstance := kpPeerAdd.Arg("instance", instanceDesc).Required().String()
+	kpPeerAddPeer := kpPeerAdd.Arg("peer", "list of key=value for the new peer").Required().StringMap()
+
+	kpPeerUpdate := kpPeer.Command("update", "change a peer in a configuration and in its live tunnel").PreAction(requireRoot)
+	kpPeerUpdateInstance := kpPeerUpdate.Arg("instance", instanceDesc).Required().String()
+	kpPeerUpdateMatch := kpPeerUpdate.Arg("match", "public key or description of the peer").Required().String()
+	kpPeerUpdatePeer := kpPeerUpdate.Arg("peer", "list of key=value to change, an empty value removes the property").Required().StringMap()
+
+	kpPeerRemove := kpPeer.Command("remove", "remove a peer from a configuration and from its live tunnel").PreAction(requireRoot)
+	kpPeerRemoveInstance := kpPeerRemove.Arg("instance", instanceDesc).Required().String()
+	kpPeerRemoveMatch := kpPeerRemove.Arg("match", "public key or description of the peer").Required().String()
+	kpPeerRemoveLive := kpPeerRemove.Flag("live", "only remove the peer from the live tunnel, without changing the configuration").Default("false").Bool()
+
+	kpPeerNew := kpPeer.Command("new", "create a peer with new keys and the next free address of the pool, and print its configuration").PreAction(requireRoot)
+	kpPeerNewInstance := kpPeerNew.Arg("instance", instanceDesc).Required().String()
+	kpPeerNewDescription := kpPeerNew.Flag("description", "description of the new peer").Short('d').String()
+	kpPeerNewEndpoint := kpPeerNew.Flag("endpoint", "endpoint the new peer should use to reach this node").Short('e').String()
+	kpPeerNewKeepalive := kpPeerNew.Flag("keepalive", "keepalive interval for the new peer").Default("25s").Duration()
+	kpPeerNewQR := qrFlags(kpPeerNew)
+
+	kpRender := kp.Command("render", "render a configuration in the wg-quick format")
+	kpRenderInstance := kpRender.Arg("instance", instanceDesc).Required().String()
+	kpRenderQR := qrFlags(kpRender)
+	kpRenderExpand := kpRender.Flag("expand", "print the YAML configuration with its includes merged and its values expanded").Default("false").Bool()
+
+	kpValidate := kp.Command("validate", "Check configurations for common mistakes.")
+	kpValidateInstance := kpValidate.Arg("instance", instanceDesc).String()
+	kpValidateAll := kpValidate.Flag("all", "check all configurations").Short('a').Default("false").Bool()
+
 	kpExport := kp.Command("export", "export the configuration of an active tunnel to YAML").PreAction(requireRoot)
 	kpExportInstance := kpExport.Arg("instance", "name of your WireGuard configuration").Required().String()
+	kpExportIncludeSecrets := kpExport.Flag("include-secrets", "write the private key inline when it is not stored in a file").Default("false").Bool()
 
 	kpKey := kp.Command("key", "Manage WireGuard keys")
 	kpKeyGenerate := kpKey.Command("private", "generate a new private key")
+	kpKeyGenerateEncrypt := kpKeyGenerate.Flag("encrypt", "encrypt the key with a passphrase").Default("false").Bool()
+	kpKeyGenerateOut := kpKeyGenerate.Flag("out", "write the key to a new file only readable by its owner, and print its public key").Short('o').String()
+	kpKeyDecrypt := kpKey.Command("decrypt", "print the private key held in an encrypted key file")
+	kpKeyDecryptFile := kpKeyDecrypt.Arg("file", "path to the encrypted key file").Required().String()
+	kpKeyReencrypt := kpKey.Command("reencrypt", "change the passphrase of a key file, or encrypt a plaintext one")
+	kpKeyReencryptFile := kpKeyReencrypt.Arg("file", "path to the key file").Required().String()
+	kpKeyRotate := kpKey.Command("rotate", "replace the private key of a tunnel and apply it, printing the new public key")
+	kpKeyRotateInstance := kpKeyRotate.Arg("instance", instanceDesc).Required().String()
+	kpKeyRotatePSK := kpKeyRotate.Flag("psk", "also replace the preshared keys of all peers").Default("false").Bool()
+	kpKeyVanity := kpKey.Command("vanity", "generate a private key whose public key starts with a prefix")
+	kpKeyVanityPrefix := kpKeyVanity.Arg("prefix", "prefix of the base64-encoded public key").Required().String()
+	kpKeyVanityIgnoreCase := kpKeyVanity.Flag("ignore-case", "match the prefix case-insensitively").Short('i').Default("false").Bool()
+	kpKeyVanityWorkers := kpKeyVanity.Flag("workers", "number of keys tried in parallel").Default(strconv.Itoa(runtime.NumCPU())).Int()
+	kpKeyVanityOut := kpKeyVanity.Flag("out", "write the key to a new file only readable by its owner, and print its public key").Short('o').String()
 	kpKeyPublic := kpKey.Command("public", "compute public key from a private key from stdin")
+	kpKeyPublicFile := kpKeyPublic.Flag("file", "read the private key from a file or secret reference").Short('f').String()
+	kpKeyPublicConfig := kpKeyPublic.Flag("config", "use the private key of a configuration").Short('c').String()
+	kpKeyInspect := kpKey.Command("inspect", "tell whether a key is a private key, a public key or a preshared key")
+	kpKeyInspectKey := kpKeyInspect.Arg("key", "key to inspect, read from stdin if not given, which should be preferred for private keys").String()
 	kpKeyPSK := kpKey.Command("psk", "generate a preshared key to be used to authenticate an endpoint")
 
+	kpSystemd := kp.Command("systemd", "Integrate tunnels with systemd")
+	kpSystemdGenerate := kpSystemd.Command("generate", "generate a service unit or networkd files for a tunnel")
+	kpSystemdGenerateInstance := kpSystemdGenerate.Arg("instance", instanceDesc).Required().String()
+	kpSystemdGenerateNetworkd := kpSystemdGenerate.Flag("networkd", "render .netdev and .network files instead of a service unit").Default("false").Bool()
+	kpSystemdGenerateWatchdog := kpSystemdGenerate.Flag("watchdog", "watchdog interval of the service unit, 0 to disable").Default("30s").Duration()
+	kpSystemdGenerateOutput := kpSystemdGenerate.Flag("output", "directory where to write the generated files instead of stdout").Short('o').String()
+
+	kpDaemon := kp.Command("daemon", "Manage tunnels from a long-running process exposing a control socket.").PreAction(requireRoot)
+	kpDaemonSocket := kpDaemon.Flag("socket", "path to the control socket").Default(lib.GetSocketPath()).String()
+
 	kpVersion := kp.Command("version", "Get version information.")
 
 	args := kingpin.MustParse(kp.Parse(os.Args[1:]))
 
+	if len(*kpEnvFile) > 0 {
+		if err := lib.LoadEnvFile(*kpEnvFile); err != nil {
+			kp.Fatalf("%s", err.Error())
+		}
+	}
+
+	// The daemon runs unattended and top owns the terminal, so neither prompts for passphrases
+	if args != kpDaemon.FullCommand() && args != kpTop.FullCommand() {
+		setUpPassphrase()
+	}
+
 	switch args {
 	case kpStart.FullCommand():
-		start(*kpStartInstance, *kpStartNoRoutes, *kpStartForeground)
+		if *kpStartAll {
+			rejectInstance(kp, *kpStartInstance)
+			startAll(*kpStartNoRoutes)
+		} else {
+			start(requireInstance(kp, *kpStartInstance), *kpStartNoRoutes, *kpStartForeground)
+		}
 	case kpStop.FullCommand():
-		stop(*kpStopInstance)
+		if *kpStopAll {
+			rejectInstance(kp, *kpStopInstance)
+			stopAll()
+		} else {
+			stop(requireInstance(kp, *kpStopInstance))
+		}
 	case kpRestart.FullCommand():
 		stop(*kpRestartInstance)
 		start(*kpRestartInstance, *kpRestartNoRoutes, false)
+	case kpSync.FullCommand():
+		syncTunnel(*kpSyncInstance)
 	case kpStatus.FullCommand():
-		status(*kpStatusInstance, *kpStatusShort, false)
+		status(*kpStatusInstance, *kpStatusShort, *kpStatusAllInterfaces, !*kpStatusNoDrift)
 	case kpInfo.FullCommand():
 		info(*kpInfoInstance)
+	case kpDiff.FullCommand():
+		diffTunnel(*kpDiffInstance)
+	case kpTop.FullCommand():
+		top(*kpTopSort, *kpTopInterval, *kpTopStale)
 	case kpSet.FullCommand():
 		set(*kpSetInstance, *kpSetParameters)
 	case kpPeerSet.FullCommand():
 		setPeers(*kpPeerSetInstance, *kpPeerSetPeer, false)
 	case kpPeerReplace.FullCommand():
 		setPeers(*kpPeerReplaceInstance, *kpPeerReplacePeer, true)
+	case kpSystemdGenerate.FullCommand():
+		generateSystemd(*kpSystemdGenerateInstance, *kpSystemdGenerateNetworkd, *kpSystemdGenerateWatchdog, *kpSystemdGenerateOutput)
+	case kpDaemon.FullCommand():
+		runDaemon(*kpDaemonSocket)
+	case kpPeerAdd.FullCommand():
+		addPeer(*kpPeerAddInstance, *kpPeerAddPeer)
+	case kpPeerUpdate.FullCommand():
+		updatePeer(*kpPeerUpdateInstance, *kpPeerUpdateMatch, *kpPeerUpdatePeer)
+	case kpPeerRemove.FullCommand():
+		removePeer(*kpPeerRemoveInstance, *kpPeerRemoveMatch, *kpPeerRemoveLive)
+	case kpPeerNew.FullCommand():
+		newPeer(*kpPeerNewInstance, *kpPeerNewDescription, *kpPeerNewEndpoint, *kpPeerNewKeepalive, *kpPeerNewQR)
 	case kpVersion.FullCommand():
 		version()
+	case kpRender.FullCommand():
+		render(*kpRenderInstance, *kpRenderQR, *kpRenderExpand)
+	case kpValidate.FullCommand():
+		if *kpValidateAll {
+			validate("", true)
+		} else {
+			validate(requireInstance(kp, *kpValidateInstance), false)
+		}
 	case kpExport.FullCommand():
-		exportConfig(*kpExportInstance)
+		exportConfig(*kpExportInstance, *kpExportIncludeSecrets)
 	case kpKeyGenerate.FullCommand():
-		generateKey()
+		generateKey(*kpKeyGenerateEncrypt, *kpKeyGenerateOut)
+	case kpKeyDecrypt.FullCommand():
+		decryptKey(*kpKeyDecryptFile)
+	case kpKeyReencrypt.FullCommand():
+		reencryptKey(*kpKeyReencryptFile)
+	case kpKeyRotate.FullCommand():
+		rotateKey(*kpKeyRotateInstance, *kpKeyRotatePSK)
+	case kpKeyVanity.FullCommand():
+		vanityKey(*kpKeyVanityPrefix, *kpKeyVanityIgnoreCase, *kpKeyVanityWorkers, *kpKeyVanityOut)
 	case kpKeyPublic.FullCommand():
-		generatePublicKey()
+		generatePublicKey(*kpKeyPublicFile, *kpKeyPublicConfig)
+	case kpKeyInspect.FullCommand():
+		inspectKey(*kpKeyInspectKey)
 	case kpKeyPSK.FullCommand():
 		generatePSK()
 	}
@@ -102,3 +239,27 @@ func requireRoot(context *kingpin.ParseContext) error {
 	}
 	return nil
 }
+
+// qrFlags registers the flags controlling QR code output on a command
+func qrFlags(cmd *kingpin.CmdClause) *qrOptions {
+	qr := new(qrOptions)
+
+	cmd.Flag("qr", "print the configuration as a QR code").Default("false").BoolVar(&qr.terminal)
+	cmd.Flag("png", "write the configuration as a QR code to a PNG file").StringVar(&qr.png)
+	cmd.Flag("invert", "invert the colors of the QR code, for terminals with a light background").Default("false").BoolVar(&qr.invert)
+
+	return qr
+}
+
+func requireInstance(kp *kingpin.Application, instance string) string {
+	if instance == "" {
+		kp.Fatalf("required argument 'instance' not provided, try --help")
+	}
+	return instance
+}
+
+func rejectInstance(kp *kingpin.Application, instance string) {
+	if instance != "" {
+		kp.Fatalf("argument 'instance' cannot be used with --all, try --help")
+	}
+}
@@ -0,0 +1,451 @@
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/apognu/wgctl/lib"
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"golang.org/x/sys/unix"
+
+	nl "github.com/vishvananda/netlink"
+)
+
+// States of a tunnel, as returned by Client.Status and Client.Inspect
+const (
+	StateUp           = "up"
+	StateDown         = "down"
+	StateNotWireGuard = "not-wireguard"
+	StateDrifted      = "up (drifted)"
+	StateUnmanaged    = "up (unmanaged)"
+)
+
+// Client manages WireGuard tunnels from their wgctl configurations, the same way the wgctl
+// commands do. It never exits the process: errors wrap ErrDeviceNotFound, ErrNotWireGuard,
+// ErrPeerNotFound or lib.ErrConfigInvalid where relevant.
+//
+// Instances are either configuration names, looked up in the configuration directory, or
+// paths to configuration files. Netlink operations cannot be interrupted, so contexts are
+// checked between the steps of an operation and used to bound lifecycle hooks.
+type Client struct {
+	// Logger receives the warnings emitted while managing tunnels, such as failed hooks
+	Logger logrus.FieldLogger
+}
+
+// TunnelInfo holds the configuration of a tunnel and the state of its live device
+type TunnelInfo struct {
+	Config *lib.Config
+	Device *wgtypes.Device
+}
+
+// NewClient returns a Client logging to the standard logrus logger
+func NewClient() *Client {
+	return &Client{Logger: logrus.StandardLogger()}
+}
+
+// Start creates and configures the device for a tunnel, sets up its routes and runs its
+// post_up hooks. It returns the normalized name of the instance.
+func (c *Client) Start(ctx context.Context, instance string, noRoutes bool) (string, error) {
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		return instance, err
+	}
+	instance = lib.GetInstanceFromArg(instance)
+
+	return instance, c.StartConfig(ctx, instance, config, noRoutes)
+}
+
+// StartConfig brings up a tunnel from an already parsed configuration. If any step fails
+// after the device was created, the device is deleted.
+func (c *Client) StartConfig(ctx context.Context, instance string, config *lib.Config, noRoutes bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := AddDevice(instance, config)
+	if err != nil {
+		return err
+	}
+
+	err = c.setUp(ctx, instance, config, noRoutes)
+	if err != nil {
+		DeleteDevice(instance)
+		return err
+	}
+
+	c.runHooks(ctx, config.Self.PostUp)
+
+	return nil
+}
+
+func (c *Client) setUp(ctx context.Context, instance string, config *lib.Config, noRoutes bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := ConfigureDevice(instance, config, true); err != nil {
+		return err
+	}
+
+	if noRoutes || !*config.Self.SetUpRoutes {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return AddDeviceRoutes(instance, config)
+}
+
+// Stop deletes the device for a tunnel and runs its pre_down hooks. It returns the normalized
+// name of the instance.
+func (c *Client) Stop(ctx context.Context, instance string) (string, error) {
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		return instance, err
+	}
+	instance = lib.GetInstanceFromArg(instance)
+
+	return instance, c.StopConfig(ctx, instance, config)
+}
+
+// StopConfig tears down a tunnel from an already parsed configuration. Hooks are run even if
+// the device was already gone, in which case the returned error wraps ErrDeviceNotFound.
+func (c *Client) StopConfig(ctx context.Context, instance string, config *lib.Config) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := DeleteDevice(instance)
+
+	c.runHooks(ctx, config.Self.PreDown)
+
+	return err
+}
+
+// Sync re-applies the configuration of a tunnel on its live device, replacing its peers and
+// updating their routes. It returns the normalized name of the instance.
+func (c *Client) Sync(ctx context.Context, instance string) (string, error) {
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		return instance, err
+	}
+	instance = lib.GetInstanceFromArg(instance)
+
+	if err := ctx.Err(); err != nil {
+		return instance, err
+	}
+
+	return instance, SyncDevice(instance, config)
+}
+
+// Apply configures the live device of a tunnel from a configuration, changing its keys and
+// updating its peers but keeping the peers that are not part of the configuration
+func (c *Client) Apply(ctx context.Context, instance string, config *lib.Config) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return ConfigureDevice(lib.GetInstanceFromArg(instance), config, false)
+}
+
+// Status returns whether the link matching an instance exists and is a WireGuard device
+func (c *Client) Status(ctx context.Context, instance string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return StateDown, err
+	}
+
+	l, err := nl.LinkByName(lib.GetInstanceFromArg(instance))
+	if err != nil {
+		if _, ok := err.(nl.LinkNotFoundError); ok {
+			return StateDown, nil
+		}
+		return StateDown, fmt.Errorf("could not find device: %w", err)
+	}
+	if l.Type() != NetlinkName {
+		return StateNotWireGuard, nil
+	}
+
+	return StateUp, nil
+}
+
+// Inspect returns the state of a tunnel like Status, but further tells apart the running
+// tunnels that differ from their configuration (StateDrifted) or that have none (StateUnmanaged).
+func (c *Client) Inspect(ctx context.Context, instance string) (string, error) {
+	state, err := c.Status(ctx, instance)
+	if err != nil || state != StateUp {
+		return state, err
+	}
+
+	drift, err := c.Diff(ctx, instance)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return StateUnmanaged, nil
+		}
+		return StateUp, err
+	}
+	if drift.Drifted() {
+		return StateDrifted, nil
+	}
+
+	return StateUp, nil
+}
+
+// Unmanaged returns the names of the WireGuard interfaces present on the system that have no
+// configuration file
+func (c *Client) Unmanaged(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	devs, err := GetDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, dev := range devs {
+		if _, err := os.Stat(lib.GetConfigFile(dev.Name)); os.IsNotExist(err) {
+			names = append(names, dev.Name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Info returns the configuration of a tunnel along with its live device
+func (c *Client) Info(ctx context.Context, instance string) (*TunnelInfo, error) {
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dev, _, err := GetDevice(lib.GetInstanceFromArg(instance))
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve device information: %w", err)
+	}
+
+	return &TunnelInfo{Config: config, Device: dev}, nil
+}
+
+// Drift holds the state a tunnel should be in according to its configuration, and the state
+// it is actually in
+type Drift struct {
+	Expected *lib.TunnelState
+	Live     *lib.TunnelState
+}
+
+// Drifted returns whether the live state of a tunnel differs from its configuration
+func (d *Drift) Drifted() bool {
+	return len(d.Diff("", "")) > 0
+}
+
+// Diff returns the unified diff from the expected state to the live state
+func (d *Drift) Diff(expectedName, liveName string) []string {
+	return lib.UnifiedDiff(d.Expected.Lines(), d.Live.Lines(), expectedName, liveName, 3)
+}
+
+// Diff compares the configuration of a tunnel with its live device, addresses, routes and rules
+func (c *Client) Diff(ctx context.Context, instance string) (*Drift, error) {
+	config, err := lib.ParseConfig(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	live, err := LiveState(lib.GetInstanceFromArg(instance), config)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve device information: %w", err)
+	}
+
+	return &Drift{Expected: lib.ExpectedState(config), Live: live}, nil
+}
+
+// Set changes properties of the live device of a tunnel
+func (c *Client) Set(ctx context.Context, instance string, config wgtypes.Config) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return SetDevice(lib.GetInstanceFromArg(instance), config, false)
+}
+
+// SetPeer adds or changes a peer on the live device of a tunnel, or replaces all its peers
+func (c *Client) SetPeer(ctx context.Context, instance string, peer wgtypes.PeerConfig, replace bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return SetDevice(lib.GetInstanceFromArg(instance), wgtypes.Config{Peers: []wgtypes.PeerConfig{peer}}, replace)
+}
+
+// UpdatePeer configures a peer of a configuration on the live device of a tunnel, replacing its
+// allowed IPs and updating the routes to them
+func (c *Client) UpdatePeer(ctx context.Context, instance string, config *lib.Config, peer *lib.Peer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return UpdatePeer(lib.GetInstanceFromArg(instance), config, peer)
+}
+
+// RemovePeer removes a peer and the routes to its allowed IPs from the live device of a tunnel
+func (c *Client) RemovePeer(ctx context.Context, instance string, publicKey wgtypes.Key) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return RemovePeer(lib.GetInstanceFromArg(instance), publicKey)
+}
+
+// exportedPSKPath is the placeholder written in place of the preshared keys that are not
+// exported
+const exportedPSKPath = "file:/path/to/preshared.key"
+
+// Export builds a configuration from the live device of a tunnel. Properties unknown to
+// WireGuard (descriptions, hooks, etc.) are taken from the configuration of the instance if
+// there is one. Private keys that are not stored in a file or a secret store are only included
+// inline if includeSecrets is set, a placeholder path is used otherwise. Preshared keys are
+// written as the secret reference they were read from or as a placeholder, unless
+// includeSecrets is set.
+func (c *Client) Export(ctx context.Context, instance string, includeSecrets bool) (*lib.Config, error) {
+	currentConfig, _ := lib.ParseConfig(instance)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	wgdev, rtdev, err := GetDevice(lib.GetInstanceFromArg(instance))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &lib.Config{
+		Self: &lib.Peer{},
+	}
+
+	addrs4, err4 := nl.AddrList(rtdev, unix.AF_INET)
+	addrs6, err6 := nl.AddrList(rtdev, unix.AF_INET6)
+	if !lib.AnyError(err4, err6) && len(addrs4)+len(addrs6) > 0 {
+		addrs := append(addrs4, addrs6...)
+		ip := addrs[0].IP
+		mask, _ := addrs[0].IPNet.Mask.Size()
+
+		config.Self.Address = &lib.IPMask{IP: ip, Mask: mask}
+	}
+
+	priv := lib.PrivateKey{Path: "/path/to/private.key"}
+	description := ""
+	preDown := [][]string{}
+	postUp := [][]string{}
+	routes := new(bool)
+	if currentConfig != nil && !currentConfig.PrivateKey.Inline() {
+		priv = currentConfig.PrivateKey
+	} else if includeSecrets {
+		priv = lib.NewPrivateKey(wgdev.PrivateKey[:])
+	}
+	if currentConfig != nil {
+		description = currentConfig.Description
+		preDown = currentConfig.Self.PreDown
+		postUp = currentConfig.Self.PostUp
+		routes = currentConfig.Self.SetUpRoutes
+	}
+
+	config.Description = description
+	config.PrivateKey = priv
+	config.Self.ListenPort = wgdev.ListenPort
+	config.Self.FWMark = wgdev.FirewallMark
+	config.Self.PreDown = preDown
+	config.Self.PostUp = postUp
+	config.Self.SetUpRoutes = routes
+
+	peers := make([]*lib.Peer, len(wgdev.Peers))
+	for idx, wgp := range wgdev.Peers {
+		description := ""
+		pskEncoding := ""
+		pskRef := exportedPSKPath
+		if currentConfig != nil {
+			if cp := currentConfig.GetPeer(wgp.PublicKey.String()); cp != nil {
+				description = cp.Description
+				pskEncoding = cp.PresharedKeyEncoding
+				if len(cp.PresharedKeyRef) > 0 {
+					pskRef = cp.PresharedKeyRef
+				}
+			}
+		}
+		if includeSecrets {
+			pskRef = ""
+		}
+
+		p := &lib.Peer{
+			Description:          description,
+			PublicKey:            lib.Key(wgp.PublicKey[:]),
+			KeepaliveInterval:    wgp.PersistentKeepaliveInterval,
+			PresharedKeyEncoding: pskEncoding,
+		}
+
+		if wgp.PresharedKey != lib.EmptyPSK {
+			psk := lib.PresharedKey(wgp.PresharedKey[:])
+			p.PresharedKey = &psk
+			p.PresharedKeyRef = pskRef
+		}
+
+		if wgp.Endpoint != nil {
+			ep := lib.UDPAddr(*wgp.Endpoint)
+			p.Endpoint = &ep
+		}
+
+		aips := make([]lib.IPNet, len(wgp.AllowedIPs))
+		for idx, aip := range wgp.AllowedIPs {
+			aips[idx] = lib.IPNet(aip)
+		}
+		p.AllowedIPS = aips
+
+		peers[idx] = p
+	}
+
+	config.Peers = peers
+
+	return config, nil
+}
+
+// runHooks runs lifecycle hooks in order, logging those that fail
+func (c *Client) runHooks(ctx context.Context, hooks [][]string) {
+	for _, cmdSpec := range hooks {
+		if err := runHook(ctx, cmdSpec); err != nil {
+			c.Logger.Warn(err)
+		}
+	}
+}
+
+func runHook(ctx context.Context, cmdSpec []string) error {
+	if len(cmdSpec) == 0 {
+		return nil
+	}
+
+	if !strings.HasPrefix(cmdSpec[0], "/") {
+		return errors.New("ignoring lifecycle hook not using an absolute path")
+	}
+
+	err := exec.CommandContext(ctx, cmdSpec[0], cmdSpec[1:]...).Run()
+	if err != nil {
+		return fmt.Errorf("lifecycle hook returned an error: %w", err)
+	}
+
+	return nil
+}
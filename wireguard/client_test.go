@@ -0,0 +1,42 @@
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ClientStatus(t *testing.T) {
+	c := NewClient()
+
+	state, err := c.Status(context.Background(), "wgmissing")
+	assert.Nil(t, err)
+	assert.Equal(t, StateDown, state)
+
+	state, err = c.Status(context.Background(), "lo")
+	assert.Nil(t, err)
+	assert.Equal(t, StateNotWireGuard, state)
+
+	state, err = c.Inspect(context.Background(), "lo")
+	assert.Nil(t, err)
+	assert.Equal(t, StateNotWireGuard, state)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = c.Status(ctx, "lo")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func Test_GetDeviceErrors(t *testing.T) {
+	_, _, err := GetDevice("wgmissing")
+	assert.True(t, errors.Is(err, ErrDeviceNotFound))
+
+	_, _, err = GetDevice("lo")
+	assert.True(t, errors.Is(err, ErrNotWireGuard))
+
+	err = DeleteDevice("wgmissing")
+	assert.True(t, errors.Is(err, ErrDeviceNotFound))
+}
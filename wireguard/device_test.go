@@ -1,13 +1,16 @@
 package wireguard
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/apognu/wgctl/lib"
 	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 func Test_SetDevice(t *testing.T) {
@@ -88,3 +91,88 @@ func Test_SetInvalidFWMark(t *testing.T) {
 
 	assert.NotNil(t, err)
 }
+
+func Test_RemovePeer(t *testing.T) {
+	instance := "wgtest"
+	peer := &lib.Peer{
+		PublicKey:  lib.GetKey(t),
+		AllowedIPS: []lib.IPNet{lib.GetSubnet(t)},
+	}
+	c := &lib.Config{
+		PrivateKey: lib.NewPrivateKey(lib.GetKey(t)),
+		Self:       &lib.Peer{ListenPort: 12345},
+		Peers:      []*lib.Peer{peer, {PublicKey: lib.GetKey(t)}},
+	}
+
+	AddDevice(instance, c)
+	assert.Nil(t, ConfigureDevice(instance, c, true))
+	assert.Nil(t, AddDeviceRoutes(instance, c))
+
+	key := wgtypes.Key(peer.PublicKey.Bytes())
+	assert.Nil(t, RemovePeer(instance, key))
+	assert.NotNil(t, RemovePeer(instance, key))
+
+	dev, _, err := GetDevice(instance)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dev.Peers))
+
+	DeleteDevice(instance)
+}
+
+func Test_LiveState(t *testing.T) {
+	instance := "wgtest"
+	c := &lib.Config{
+		PrivateKey: lib.NewPrivateKey(lib.GetKey(t)),
+		Self: &lib.Peer{
+			Address:    &lib.IPMask{IP: net.ParseIP("198.18.100.1"), Mask: 24},
+			ListenPort: 12345,
+		},
+		Peers: []*lib.Peer{
+			{
+				PublicKey:         lib.GetKey(t),
+				PresharedKey:      lib.GetPSK(t),
+				KeepaliveInterval: 30 * time.Second,
+				AllowedIPS:        []lib.IPNet{lib.GetSubnet(t)},
+			},
+		},
+	}
+
+	AddDevice(instance, c)
+	assert.Nil(t, ConfigureDevice(instance, c, true))
+	assert.Nil(t, AddDeviceRoutes(instance, c))
+
+	live, err := LiveState(instance, c)
+	assert.Nil(t, err)
+	assert.Equal(t, lib.ExpectedState(c).Lines(), live.Lines())
+
+	extra, _ := wgtypes.NewKey(lib.GetKey(t))
+	peers := []wgtypes.PeerConfig{{PublicKey: extra}}
+	assert.Nil(t, SetDevice(instance, wgtypes.Config{Peers: peers}, false))
+
+	live, err = LiveState(instance, c)
+	assert.Nil(t, err)
+	assert.NotEqual(t, lib.ExpectedState(c).Lines(), live.Lines())
+
+	DeleteDevice(instance)
+}
+
+func Test_Unmanaged(t *testing.T) {
+	instance := "wgtest"
+	os.Setenv("WGCTL_CONFIG_PATH", os.TempDir())
+	defer os.Unsetenv("WGCTL_CONFIG_PATH")
+
+	AddDevice(instance, &lib.Config{})
+
+	c := NewClient()
+
+	state, err := c.Inspect(context.Background(), instance)
+	assert.Nil(t, err)
+	assert.Equal(t, StateUnmanaged, state)
+
+	names, err := c.Unmanaged(context.Background())
+	assert.Nil(t, err)
+	assert.Contains(t, names, instance)
+
+	DeleteDevice(instance)
+}
@@ -0,0 +1,12 @@
+package wireguard
+
+import "errors"
+
+var (
+	// ErrDeviceNotFound is wrapped by errors caused by a missing network interface
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrNotWireGuard is wrapped by errors caused by an interface that is not a WireGuard device
+	ErrNotWireGuard = errors.New("device is not a WireGuard interface")
+	// ErrPeerNotFound is wrapped by errors caused by a peer missing from a live device
+	ErrPeerNotFound = errors.New("peer not found")
+)
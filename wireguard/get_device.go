@@ -3,7 +3,6 @@ package wireguard
 import (
 	"fmt"
 
-	"github.com/apognu/wgctl/lib"
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
@@ -14,14 +13,41 @@ import (
 func GetDevice(ifname string) (*wgtypes.Device, nl.Link, error) {
 	nlcl, err := wgctrl.New()
 	if err != nil {
-		return nil, nil, fmt.Errorf("could not create wireguard client: %s", err.Error())
+		return nil, nil, fmt.Errorf("could not create wireguard client: %w", err)
 	}
+	defer nlcl.Close()
 
-	dev, errwg := nlcl.Device(ifname)
-	link, errrt := nl.LinkByName(ifname)
-	if lib.AnyError(errwg, errrt) {
-		return nil, nil, fmt.Errorf("could not find device: %s", lib.FirstError(errwg, errrt))
+	link, err := nl.LinkByName(ifname)
+	if err != nil {
+		if _, ok := err.(nl.LinkNotFoundError); ok {
+			return nil, nil, fmt.Errorf("could not find device '%s': %w", ifname, ErrDeviceNotFound)
+		}
+		return nil, nil, fmt.Errorf("could not find device: %w", err)
+	}
+	if link.Type() != NetlinkName {
+		return nil, nil, fmt.Errorf("could not use device '%s': %w", ifname, ErrNotWireGuard)
+	}
+
+	dev, err := nlcl.Device(ifname)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not find device: %w", err)
 	}
 
 	return dev, link, nil
 }
+
+// GetDevices returns all WireGuard interfaces present on the system
+func GetDevices() ([]*wgtypes.Device, error) {
+	nlcl, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("could not create wireguard client: %w", err)
+	}
+	defer nlcl.Close()
+
+	devs, err := nlcl.Devices()
+	if err != nil {
+		return nil, fmt.Errorf("could not list devices: %w", err)
+	}
+
+	return devs, nil
+}
@@ -1,12 +1,15 @@
 package wireguard
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strings"
 
 	"github.com/apognu/wgctl/lib"
 	sysctl "github.com/lorenzosaino/go-sysctl"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	nl "github.com/vishvananda/netlink"
 )
@@ -38,24 +41,24 @@ func AddDevice(instance string, config *lib.Config) error {
 	err1 := nl.LinkAdd(&WGLink{LinkAttrs: attrs})
 	l, err2 := nl.LinkByName(instance)
 	if lib.AnyError(err1, err2) {
-		return fmt.Errorf("could not find recently created device: %s", lib.FirstError(err1, err2))
+		return fmt.Errorf("could not find recently created device: %w", lib.FirstError(err1, err2))
 	}
 
 	if config.Self != nil && config.Self.Address != nil {
 		ip := config.Self.Address
 		addr, err := nl.ParseAddr(ip.String())
 		if err != nil {
-			return fmt.Errorf("could not set device's IP address: %s", err.Error())
+			return fmt.Errorf("could not set device's IP address: %w", err)
 		}
 
 		err = nl.AddrAdd(l, addr)
 		if err != nil {
-			return fmt.Errorf("could not set device's IP address: %s", err.Error())
+			return fmt.Errorf("could not set device's IP address: %w", err)
 		}
 	}
 
 	if err := nl.LinkSetUp(l); err != nil {
-		return fmt.Errorf("could bring up device: %s", err.Error())
+		return fmt.Errorf("could bring up device: %w", err)
 	}
 
 	return nil
@@ -65,7 +68,7 @@ func AddDevice(instance string, config *lib.Config) error {
 func AddDeviceRoutes(instance string, config *lib.Config) error {
 	l, err := nl.LinkByName(instance)
 	if err != nil {
-		return fmt.Errorf("could not find recently created device: %s", err.Error())
+		return fmt.Errorf("could not find recently created device: %w", err)
 	}
 
 	for _, p := range config.Peers {
@@ -73,40 +76,112 @@ func AddDeviceRoutes(instance string, config *lib.Config) error {
 			continue
 		}
 
-		for _, ip := range p.AllowedIPS {
-			sub := net.IPNet(ip)
-			if strings.HasSuffix(sub.String(), "/0") {
-				err := SetFWMark(instance, config.Self.ListenPort)
-				if err != nil {
-					return err
-				}
-				err = SetRPFilter()
-				if err != nil {
-					return err
-				}
-				err = AddCatchAllRoute(l, sub, config)
-				if err != nil {
-					return err
-				}
-			} else {
-				n := net.IPNet(ip)
-				err := nl.RouteAdd(&nl.Route{Dst: &n, LinkIndex: l.Attrs().Index})
-				if err != nil {
-					return fmt.Errorf("could not add route: %s", err.Error())
-				}
+		if err := addRoutes(instance, l, config, allowedIPs(p)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdatePeerRoutes changes the routes set up by AddDeviceRoutes for the peers of a live device,
+// from the allowed IPs they had (previous) to those they are configured with (current). The
+// routes of previous peers that are not part of current are deleted.
+func UpdatePeerRoutes(instance string, config *lib.Config, previous []wgtypes.Peer, current []*lib.Peer) error {
+	if config.Self.SetUpRoutes != nil && !*config.Self.SetUpRoutes {
+		return nil
+	}
+
+	l, err := nl.LinkByName(instance)
+	if err != nil {
+		return fmt.Errorf("could not find device: %w", err)
+	}
+
+	removedIPs := make([]net.IPNet, 0)
+	prev := make(map[string][]net.IPNet)
+	for _, p := range previous {
+		prev[p.PublicKey.String()] = p.AllowedIPs
+	}
+
+	for _, p := range current {
+		// A peer that was not on the device (e.g. its public key changed) gets all its routes
+		added, removed := lib.DiffAllowedIPs(prev[p.PublicKey.String()], allowedIPs(p))
+		delete(prev, p.PublicKey.String())
+		removedIPs = append(removedIPs, removed...)
+
+		if err := DeletePeerRoutes(l, config.Self.ListenPort, removed); err != nil {
+			return err
+		}
+
+		for _, ip := range added {
+			// Routes may already exist (e.g. an allowed IP moved from another peer)
+			if err := addRoutes(instance, l, config, []net.IPNet{ip}); err != nil && !errors.Is(err, unix.EEXIST) {
+				return err
 			}
 		}
 	}
 
+	for _, ips := range prev {
+		if err := DeletePeerRoutes(l, config.Self.ListenPort, ips); err != nil {
+			return err
+		}
+		removedIPs = append(removedIPs, ips...)
+	}
+
+	remaining := make([][]net.IPNet, len(config.Peers))
+	for idx, p := range config.Peers {
+		remaining[idx] = allowedIPs(p)
+	}
+	if lib.CatchAllRemoved(removedIPs, remaining...) {
+		DeleteCatchAllRules()
+	}
+
 	return nil
 }
 
+// addRoutes sets up the routes to a list of allowed IPs, through the policy routing of catch-all
+// routes where needed
+func addRoutes(instance string, l nl.Link, config *lib.Config, ips []net.IPNet) error {
+	for _, ip := range ips {
+		sub := ip
+		if strings.HasSuffix(sub.String(), "/0") {
+			err := SetFWMark(instance, config.Self.ListenPort)
+			if err != nil {
+				return err
+			}
+			err = SetRPFilter()
+			if err != nil {
+				return err
+			}
+			err = AddCatchAllRoute(l, sub, config)
+			if err != nil {
+				return err
+			}
+		} else {
+			err := nl.RouteAdd(&nl.Route{Dst: &sub, LinkIndex: l.Attrs().Index})
+			if err != nil {
+				return fmt.Errorf("could not add route: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func allowedIPs(p *lib.Peer) []net.IPNet {
+	ips := make([]net.IPNet, len(p.AllowedIPS))
+	for idx, ip := range p.AllowedIPS {
+		ips[idx] = net.IPNet(ip)
+	}
+	return ips
+}
+
 // AddCatchAllRoute sets up routing to forward all traffic
 func AddCatchAllRoute(l nl.Link, dst net.IPNet, config *lib.Config) error {
 	r := &nl.Route{Dst: &dst, LinkIndex: l.Attrs().Index, Table: config.Self.ListenPort}
 	err := nl.RouteAdd(r)
 	if err != nil {
-		return fmt.Errorf("could not add route: %s", err.Error())
+		return fmt.Errorf("could not add route: %w", err)
 	}
 
 	rule := nl.NewRule()
@@ -116,7 +191,7 @@ func AddCatchAllRoute(l nl.Link, dst net.IPNet, config *lib.Config) error {
 
 	err = nl.RuleAdd(rule)
 	if err != nil {
-		return fmt.Errorf("could not add suppress prefix length: %s", err.Error())
+		return fmt.Errorf("could not add suppress prefix length: %w", err)
 	}
 
 	rule = nl.NewRule()
@@ -127,7 +202,7 @@ func AddCatchAllRoute(l nl.Link, dst net.IPNet, config *lib.Config) error {
 
 	err = nl.RuleAdd(rule)
 	if err != nil {
-		return fmt.Errorf("could not add fwmark: %s", err.Error())
+		return fmt.Errorf("could not add fwmark: %w", err)
 	}
 
 	return nil
@@ -137,14 +212,25 @@ func AddCatchAllRoute(l nl.Link, dst net.IPNet, config *lib.Config) error {
 func DeleteDevice(instance string) error {
 	l, err := nl.LinkByName(instance)
 	if err != nil {
-		return fmt.Errorf("could not delete device: %s", err.Error())
+		if _, ok := err.(nl.LinkNotFoundError); ok {
+			return fmt.Errorf("could not delete device '%s': %w", instance, ErrDeviceNotFound)
+		}
+		return fmt.Errorf("could not delete device: %w", err)
 	}
 
 	err = nl.LinkDel(l)
 	if err != nil {
-		return fmt.Errorf("could not delete device: %s", err.Error())
+		return fmt.Errorf("could not delete device: %w", err)
 	}
 
+	DeleteCatchAllRules()
+
+	return nil
+}
+
+// DeleteCatchAllRules deletes the policy routing rules set up by AddCatchAllRoute. Rules that
+// do not exist are ignored.
+func DeleteCatchAllRules() {
 	rule1 := nl.NewRule()
 	rule1.Priority = 32000
 	rule2 := *rule1
@@ -152,6 +238,23 @@ func DeleteDevice(instance string) error {
 
 	nl.RuleDel(rule1)
 	nl.RuleDel(&rule2)
+}
+
+// DeletePeerRoutes deletes the routes set up by AddDeviceRoutes for the allowed IPs of a peer.
+// Routes that do not exist (e.g. the tunnel was started without routes) are ignored.
+func DeletePeerRoutes(l nl.Link, table int, ips []net.IPNet) error {
+	for _, ip := range ips {
+		dst := ip
+		r := &nl.Route{Dst: &dst, LinkIndex: l.Attrs().Index}
+		if ones, _ := dst.Mask.Size(); ones == 0 {
+			r.Table = table
+		}
+
+		err := nl.RouteDel(r)
+		if err != nil && err != unix.ESRCH {
+			return fmt.Errorf("could not delete route: %w", err)
+		}
+	}
 
 	return nil
 }
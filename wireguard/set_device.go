@@ -5,7 +5,6 @@ import (
 	"net"
 
 	"github.com/apognu/wgctl/lib"
-	"github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
@@ -14,12 +13,16 @@ import (
 func SetFWMark(instance string, fwmark int) error {
 	nlcl, err := wgctrl.New()
 	if err != nil {
-		logrus.Fatalf("could not create wireguard client: %s", err.Error())
+		return fmt.Errorf("could not create wireguard client: %w", err)
 	}
+	defer nlcl.Close()
 
 	err = nlcl.ConfigureDevice(instance, wgtypes.Config{FirewallMark: &fwmark})
+	if err != nil {
+		return fmt.Errorf("could not set fwmark: %w", err)
+	}
 
-	return err
+	return nil
 }
 
 // SetDevice sets individual properties on a wireguard device without creating low-level
@@ -27,19 +30,99 @@ func SetFWMark(instance string, fwmark int) error {
 func SetDevice(instance string, config wgtypes.Config, replacePeers bool) error {
 	nlcl, err := wgctrl.New()
 	if err != nil {
-		return fmt.Errorf("could not create wireguard client: %s", err.Error())
+		return fmt.Errorf("could not create wireguard client: %w", err)
 	}
+	defer nlcl.Close()
 
 	config.ReplacePeers = replacePeers
 
 	err = nlcl.ConfigureDevice(instance, config)
 	if err != nil {
-		return fmt.Errorf("could not configure wireguard client: %s", err.Error())
+		return fmt.Errorf("could not configure wireguard client: %w", err)
+	}
+
+	return nil
+}
+
+// RemovePeer removes a peer from a live device, along with the routes set up for its allowed IPs
+func RemovePeer(instance string, publicKey wgtypes.Key) error {
+	dev, link, err := GetDevice(instance)
+	if err != nil {
+		return err
+	}
+
+	var peer *wgtypes.Peer
+	for idx := range dev.Peers {
+		if dev.Peers[idx].PublicKey == publicKey {
+			peer = &dev.Peers[idx]
+		}
+	}
+	if peer == nil {
+		return fmt.Errorf("could not find peer '%s' on device: %w", publicKey.String(), ErrPeerNotFound)
+	}
+
+	err = SetDevice(instance, wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: publicKey, Remove: true}}}, false)
+	if err != nil {
+		return err
+	}
+
+	if err := DeletePeerRoutes(link, dev.ListenPort, peer.AllowedIPs); err != nil {
+		return err
+	}
+
+	remaining := make([][]net.IPNet, 0, len(dev.Peers))
+	for _, p := range dev.Peers {
+		if p.PublicKey != publicKey {
+			remaining = append(remaining, p.AllowedIPs)
+		}
+	}
+	if lib.CatchAllRemoved(peer.AllowedIPs, remaining...) {
+		DeleteCatchAllRules()
 	}
 
 	return nil
 }
 
+// SyncDevice re-applies a configuration on a live device, replacing its peers, and updates the
+// routes to their allowed IPs
+func SyncDevice(instance string, config *lib.Config) error {
+	dev, _, err := GetDevice(instance)
+	if err != nil {
+		return err
+	}
+
+	if err := ConfigureDevice(instance, config, true); err != nil {
+		return err
+	}
+
+	return UpdatePeerRoutes(instance, config, dev.Peers, config.Peers)
+}
+
+// UpdatePeer configures a peer of a configuration on a live device, replacing its allowed IPs,
+// and updates the routes to them
+func UpdatePeer(instance string, config *lib.Config, peer *lib.Peer) error {
+	dev, _, err := GetDevice(instance)
+	if err != nil {
+		return err
+	}
+
+	previous := make([]wgtypes.Peer, 0)
+	for _, p := range dev.Peers {
+		if p.PublicKey.String() == peer.PublicKey.String() {
+			previous = append(previous, p)
+		}
+	}
+
+	pc := ParsePeer(peer)
+	pc.ReplaceAllowedIPs = true
+
+	if err := SetDevice(instance, wgtypes.Config{Peers: []wgtypes.PeerConfig{pc}}, false); err != nil {
+		return err
+	}
+
+	return UpdatePeerRoutes(instance, config, previous, []*lib.Peer{peer})
+}
+
 // ConfigureDevice sets all WireGuard parameter in a Config
 func ConfigureDevice(instance string, config *lib.Config, replacePeers bool) error {
 	priv := wgtypes.Key(config.PrivateKey.Bytes())
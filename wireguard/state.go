@@ -0,0 +1,109 @@
+package wireguard
+
+import (
+	"time"
+
+	"github.com/apognu/wgctl/lib"
+	"golang.org/x/sys/unix"
+
+	nl "github.com/vishvananda/netlink"
+)
+
+// LiveState describes the running device of a tunnel along with its addresses, routes and
+// policy routing rules. Peer descriptions are taken from the configuration, which is also
+// used to ignore the endpoints learnt from roaming peers that have none configured.
+func LiveState(instance string, config *lib.Config) (*lib.TunnelState, error) {
+	dev, link, err := GetDevice(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &lib.TunnelState{
+		PublicKey:  dev.PublicKey.String(),
+		ListenPort: dev.ListenPort,
+		FWMark:     dev.FirewallMark,
+		Addresses:  []string{},
+		Peers:      []lib.PeerState{},
+		Routes:     []string{},
+		Rules:      []string{},
+	}
+
+	addrs, err := nl.AddrList(link, nl.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ones, _ := addr.Mask.Size()
+		state.Addresses = append(state.Addresses, lib.IPMask{IP: addr.IP, Mask: ones}.String())
+	}
+
+	for _, p := range dev.Peers {
+		var configured *lib.Peer
+		if config != nil {
+			configured = config.GetPeer(p.PublicKey.String())
+		}
+
+		ps := lib.PeerState{
+			PublicKey:    p.PublicKey.String(),
+			PresharedKey: p.PresharedKey != lib.EmptyPSK,
+			Keepalive:    p.PersistentKeepaliveInterval.Truncate(time.Second),
+			AllowedIPs:   []string{},
+		}
+		if configured != nil {
+			ps.Description = configured.Description
+		}
+		if p.Endpoint != nil && (configured == nil || configured.Endpoint != nil) {
+			ep, _ := lib.UDPAddr(*p.Endpoint).MarshalYAML()
+			ps.Endpoint = ep.(string)
+		}
+		for _, ip := range p.AllowedIPs {
+			ps.AllowedIPs = append(ps.AllowedIPs, ip.String())
+		}
+
+		state.Peers = append(state.Peers, ps)
+	}
+
+	routes, err := nl.RouteListFiltered(nl.FAMILY_ALL, &nl.Route{LinkIndex: link.Attrs().Index, Table: unix.RT_TABLE_UNSPEC}, nl.RT_FILTER_OIF|nl.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		// Routes added by the kernel for interface addresses are not managed by wgctl
+		if r.Protocol == unix.RTPROT_KERNEL || r.Table == unix.RT_TABLE_LOCAL || r.Dst == nil || r.Dst.IP.IsMulticast() {
+			continue
+		}
+
+		table := 0
+		if r.Table != unix.RT_TABLE_MAIN {
+			table = r.Table
+		}
+
+		state.Routes = append(state.Routes, lib.FormatRoute(r.Dst.String(), table))
+	}
+
+	rules, err := nl.RuleList(nl.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+	// The suppressing rule is shared by all tunnels with catch-all routes, so it is only
+	// attributed to this one if it also has its own rule
+	suppress := false
+	for _, r := range rules {
+		switch {
+		case r.Priority == lib.SuppressRulePriority && r.SuppressPrefixlen == 0 && r.Table == unix.RT_TABLE_MAIN:
+			suppress = true
+		case r.Priority == lib.FWMarkRulePriority && r.Table == dev.ListenPort && r.Invert:
+			state.Rules = append(state.Rules, lib.FormatFWMarkRule(r.Mark))
+		}
+	}
+	if suppress && len(state.Rules) > 0 {
+		state.Rules = append(state.Rules, lib.FormatSuppressRule())
+	}
+
+	state.Sort()
+
+	return state, nil
+}